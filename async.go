@@ -0,0 +1,203 @@
+package zapsentry
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// DropPolicy defines what happens to an event when the async queue is full.
+type DropPolicy int
+
+const (
+	// DropNewest drops the event which is being queued.
+	DropNewest DropPolicy = iota
+	// DropOldest drops the oldest queued event to make room for the new one.
+	DropOldest
+	// Block blocks the logging goroutine until there is room in the queue, or the block
+	// timeout passes, in which case the event is dropped.
+	Block
+)
+
+// AsyncConfig configures sending events asynchronously.
+// Zero values are replaced with the defaults.
+type AsyncConfig struct {
+	// QueueSize is the maximum number of queued events. Defaults to 1000.
+	QueueSize int
+	// Workers is the number of goroutines sending queued events. Defaults to 1.
+	Workers int
+	// DropPolicy defines what happens to an event when the queue is full.
+	// Defaults to DropNewest.
+	DropPolicy DropPolicy
+	// BlockTimeout is the maximum time the Block drop policy blocks for.
+	// Defaults to 100ms.
+	BlockTimeout time.Duration
+}
+
+// asyncDefaults are the sane defaults for async configuration.
+var asyncDefaults = AsyncConfig{
+	QueueSize:    1000,
+	Workers:      1,
+	DropPolicy:   DropNewest,
+	BlockTimeout: 100 * time.Millisecond,
+}
+
+// asyncQueue is a bounded queue of events built on the logging goroutine, served by worker
+// goroutines which symbolize their stack traces and capture them.
+// Workers run until the queue is closed.
+type asyncQueue struct {
+	queue        chan *draft
+	dropPolicy   DropPolicy
+	blockTimeout time.Duration
+
+	// closeMu guards closed, it's held for reading while queueing so the queue isn't closed
+	// while an event is pushed.
+	closeMu sync.RWMutex
+	// closed is true once the queue is closed, events are captured synchronously after.
+	closed bool
+	// workers waits for the workers to return.
+	workers sync.WaitGroup
+
+	// mu guards pending and drained.
+	mu sync.Mutex
+	// pending is the number of queued and in-flight events.
+	pending int
+	// drained is closed once pending drops to zero.
+	drained chan struct{}
+}
+
+func newAsyncQueue(cfg AsyncConfig) (*asyncQueue, error) {
+	if cfg.QueueSize < 0 || cfg.Workers < 0 || cfg.BlockTimeout < 0 {
+		return nil, errors.New("async queue size, workers and block timeout can't be negative")
+	}
+	if cfg.QueueSize == 0 {
+		cfg.QueueSize = asyncDefaults.QueueSize
+	}
+	if cfg.Workers == 0 {
+		cfg.Workers = asyncDefaults.Workers
+	}
+	if cfg.BlockTimeout == 0 {
+		cfg.BlockTimeout = asyncDefaults.BlockTimeout
+	}
+
+	q := &asyncQueue{
+		queue:        make(chan *draft, cfg.QueueSize),
+		dropPolicy:   cfg.DropPolicy,
+		blockTimeout: cfg.BlockTimeout,
+	}
+	q.workers.Add(cfg.Workers)
+	for i := 0; i < cfg.Workers; i++ {
+		go q.work()
+	}
+	return q, nil
+}
+
+// enqueue queues the event, applying the drop policy if the queue is full.
+// The event is captured synchronously if the queue is closed.
+func (q *asyncQueue) enqueue(d *draft) {
+	q.closeMu.RLock()
+	defer q.closeMu.RUnlock()
+	if q.closed {
+		d.capture()
+		return
+	}
+
+	q.add()
+	if !q.push(d) {
+		q.done()
+	}
+}
+
+// push queues the event according to the drop policy.
+// It returns false if the event was dropped.
+func (q *asyncQueue) push(d *draft) bool {
+	select {
+	case q.queue <- d:
+		return true
+	default:
+	}
+
+	switch q.dropPolicy {
+	case DropOldest:
+		for {
+			select {
+			case q.queue <- d:
+				return true
+			case <-q.queue:
+				q.done()
+			}
+		}
+	case Block:
+		timer := time.NewTimer(q.blockTimeout)
+		defer timer.Stop()
+		select {
+		case q.queue <- d:
+			return true
+		case <-timer.C:
+			return false
+		}
+	default:
+		return false
+	}
+}
+
+// work captures the queued events, until the queue is closed.
+func (q *asyncQueue) work() {
+	defer q.workers.Done()
+	for d := range q.queue {
+		d.capture()
+		q.done()
+	}
+}
+
+// close closes the queue and waits for the workers to capture the queued events and return.
+func (q *asyncQueue) close() {
+	q.closeMu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.queue)
+	}
+	q.closeMu.Unlock()
+	q.workers.Wait()
+}
+
+// add marks an event as pending.
+func (q *asyncQueue) add() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.pending == 0 {
+		q.drained = make(chan struct{})
+	}
+	q.pending++
+}
+
+// done marks a pending event as captured or dropped.
+func (q *asyncQueue) done() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.pending--
+	if q.pending == 0 {
+		close(q.drained)
+	}
+}
+
+// drain waits until all pending events are captured, or the timeout passes.
+// It returns false if the timeout passed.
+func (q *asyncQueue) drain(timeout time.Duration) bool {
+	q.mu.Lock()
+	if q.pending == 0 {
+		q.mu.Unlock()
+		return true
+	}
+	drained := q.drained
+	q.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-drained:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
@@ -59,11 +59,15 @@ type breadcrumbs struct {
 	// enabled is true if breadcrumbs are enabled
 	enabled bool
 
-	// level is the level after which breadcrumbs will be added
-	level zapcore.Level
+	// level is the level after which breadcrumbs will be added, it can be changed at runtime
+	// if it's a dynamic level like zap.AtomicLevel
+	level zapcore.LevelEnabler
 
 	// localOnly
 	localOnly bool
+
+	// scrubber scrubs sensitive data, nil if scrubbing is disabled
+	scrubber *scrubber
 }
 
 // newBreadcrumbs returns new breadcrumbs with default settings.
@@ -72,12 +76,13 @@ func newBreadcrumbs() *breadcrumbs { return &breadcrumbs{localOnly: true} }
 // Enabled returns true if the given level is at or above the breadcrumbs level.
 // It also checks if breadcrumbs are enabled.
 func (bc *breadcrumbs) Enabled(lvl zapcore.Level) bool {
-	return bc.enabled && bc.level.Enabled(lvl) && bc.level != zapcore.ErrorLevel
+	return bc.enabled && bc.level.Enabled(lvl) && levelOf(bc.level) != zapcore.ErrorLevel
 }
 
 // new returns a new sentry Breadcrumb from the passed zapcore.Entry and data.
+// Sensitive data is scrubbed if scrubbing is enabled.
 func (bc *breadcrumbs) new(ent zapcore.Entry, data map[string]interface{}) *sentry.Breadcrumb {
-	return &sentry.Breadcrumb{
+	breadcrumb := &sentry.Breadcrumb{
 		Data:      data,
 		Message:   ent.Message,
 		Level:     zapToSentryLevel(ent.Level),
@@ -85,6 +90,10 @@ func (bc *breadcrumbs) new(ent zapcore.Entry, data map[string]interface{}) *sent
 		Category:  zapLevelToBreadcrumbCategory(ent.Level),
 		Timestamp: ent.Time,
 	}
+	if bc.scrubber != nil {
+		bc.scrubber.scrubBreadcrumb(breadcrumb)
+	}
+	return breadcrumb
 }
 
 // zapLevelToBreadcrumbType maps zap's Level to Sentry's breadcrumb type.
@@ -0,0 +1,21 @@
+package zapsentry
+
+import "time"
+
+// clock is the time source of deduplication windows and rate limits, replaced in tests.
+type clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// AfterFunc calls f in it's own goroutine after d, unless the returned stop function is
+	// called first. Stop returns false if f was already called.
+	AfterFunc(d time.Duration, f func()) (stop func() bool)
+}
+
+// systemClock is the clock of the time package.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) AfterFunc(d time.Duration, f func()) func() bool {
+	return time.AfterFunc(d, f).Stop
+}
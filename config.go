@@ -40,6 +40,18 @@ func Level(lvl zapcore.Level) Option {
 	}
 }
 
+// WithLevelEnabler enables events by the passed LevelEnabler instead of a fixed level.
+// Passing a zap.AtomicLevel allows changing the level at runtime, e.g. through it's HTTP handler.
+func WithLevelEnabler(enab zapcore.LevelEnabler) Option {
+	return func(c *core) error {
+		if enab == nil {
+			return errors.New("level enabler can't be nil")
+		}
+		c.level = enab
+		return nil
+	}
+}
+
 func WithTags(tags map[string]string) Option {
 	return func(c *core) error {
 		c.events.tags = tags
@@ -47,6 +59,40 @@ func WithTags(tags map[string]string) Option {
 	}
 }
 
+// WithEnrichment adds functions called for every event, which return tags, contexts and
+// extra added to it. Unlike WithTags, the values can change for every event.
+// Their tags override the ones set with WithTags and converted from fields, but not the
+// ones set with Tag fields. Their contexts and extra don't override the ones from fields.
+func WithEnrichment(fns ...EnrichmentFunc) Option {
+	return func(c *core) error {
+		for _, fn := range fns {
+			if fn == nil {
+				return errors.New("enrichment func can't be nil")
+			}
+		}
+		c.events.enrichments = append(c.events.enrichments, fns...)
+		return nil
+	}
+}
+
+// WithBeforeCapture adds hooks called with every draft event and the entry and fields it
+// was built from. Hooks can modify the event or drop it by returning nil, and are called in
+// order.
+// Hooks are called on the logging goroutine, even with WithAsync, after sampling but
+// before deduplication and rate limiting, so dropped events don't count as repeats and
+// don't consume the rate limit. With WithAsync, exception stack traces have no frames yet.
+func WithBeforeCapture(fns ...BeforeCaptureFunc) Option {
+	return func(c *core) error {
+		for _, fn := range fns {
+			if fn == nil {
+				return errors.New("before capture func can't be nil")
+			}
+		}
+		c.events.beforeCapture = append(c.events.beforeCapture, fns...)
+		return nil
+	}
+}
+
 func WithEnvironment(env string) Option {
 	return func(c *core) error {
 		c.events.environment = env
@@ -71,6 +117,31 @@ func UseStacktraceFrameFilter(ff StacktraceFrameFilter) Option {
 	}
 }
 
+// RegisterStacktraceExtractors registers extractors used to find the stack traces carried by
+// logged errors. They are tried in order, before the DefaultStacktraceExtractors.
+func RegisterStacktraceExtractors(extractors ...StacktraceExtractor) Option {
+	return func(c *core) error {
+		if c.events.disabledStacktrace {
+			return errors.New("stacktrace disabled, don't pass stacktrace extractors opt")
+		}
+		c.events.stacktraceExtractors = append(c.events.stacktraceExtractors, extractors...)
+		return nil
+	}
+}
+
+// UseEntryStacktrace makes exceptions reuse the stack trace zap took for the entry when the
+// logger is built with zap.AddStacktrace, instead of walking the stack again.
+// Entries without a stack trace still get a new one.
+func UseEntryStacktrace() Option {
+	return func(c *core) error {
+		if c.events.disabledStacktrace {
+			return errors.New("stacktrace disabled, don't pass entry stacktrace opt")
+		}
+		c.events.useEntryStacktrace = true
+		return nil
+	}
+}
+
 func DisableStacktrace() Option {
 	return func(c *core) error {
 		c.events.disabledStacktrace = true
@@ -79,6 +150,33 @@ func DisableStacktrace() Option {
 	}
 }
 
+// WithFingerprinter sets the Fingerprinter used to group events into sentry issues.
+// Fingerprint fields passed to the logger take precedence over it.
+func WithFingerprinter(fp Fingerprinter) Option {
+	return func(c *core) error {
+		if fp == nil {
+			return errors.New("fingerprinter can't be nil")
+		}
+		c.events.fingerprinter = fp
+		return nil
+	}
+}
+
+// NormalizeMessages enables rewriting the variable parts of messages, like IDs and numbers,
+// before they become event messages and exception types. The original message is kept in
+// the event extra. The passed rules are applied before the DefaultNormalizationRules.
+func NormalizeMessages(rules ...NormalizationRule) Option {
+	return func(c *core) error {
+		for _, r := range rules {
+			if r.Pattern == nil {
+				return errors.New("normalization rule pattern can't be nil")
+			}
+		}
+		c.events.normalizer = NewMessageNormalizer(rules...)
+		return nil
+	}
+}
+
 func WithBreadcrumbs(level zapcore.Level) Option {
 	return func(c *core) error {
 		c.breadcrumbs.enabled = true
@@ -87,6 +185,19 @@ func WithBreadcrumbs(level zapcore.Level) Option {
 	}
 }
 
+// WithBreadcrumbsLevelEnabler enables breadcrumbs by the passed LevelEnabler instead of a fixed
+// level. Passing a zap.AtomicLevel allows changing the level at runtime.
+func WithBreadcrumbsLevelEnabler(enab zapcore.LevelEnabler) Option {
+	return func(c *core) error {
+		if enab == nil {
+			return errors.New("breadcrumbs level enabler can't be nil")
+		}
+		c.breadcrumbs.enabled = true
+		c.breadcrumbs.level = enab
+		return nil
+	}
+}
+
 func WithGlobalBreadcrumbs() Option {
 	return func(c *core) error {
 		c.breadcrumbs.localOnly = false
@@ -104,6 +215,75 @@ func WithFlushTimeout(after time.Duration) Option {
 	}
 }
 
+// WithAsync makes the core send events asynchronously, so logging never blocks on sentry.
+// Events are built on the logging goroutine, since building them reads the fields, and
+// queued in a bounded queue served by worker goroutines, which symbolize the stack traces
+// and capture them. Fingerprinters and before capture hooks get exceptions which stack
+// traces don't have frames yet.
+// Sync waits for the queue to drain within the flush timeout.
+func WithAsync(cfg AsyncConfig) Option {
+	return func(c *core) error {
+		c.asyncConfig = &cfg
+		return nil
+	}
+}
+
+// WithIgnoreRules skips creating events for entries which are known noise, like errors
+// wrapping context.Canceled. Ignored entries are kept as breadcrumbs only if the rule
+// says so.
+func WithIgnoreRules(rules ...IgnoreRule) Option {
+	return func(c *core) error {
+		for _, r := range rules {
+			if err := r.validate(); err != nil {
+				return err
+			}
+		}
+		c.ignoreRules = append(c.ignoreRules, rules...)
+		return nil
+	}
+}
+
+// WithSampling samples events by level and logger name, e.g. to send all fatal events,
+// 20% of error events from the cache logger and 1% of warn events.
+// Events without a matching rule are always sent. The decision is consistent for a
+// fingerprint, so a sampled in issue is always sampled in.
+// Events are sampled before they are built, so the configured Fingerprinter gets the
+// exceptions of the error fields without stack traces, and without the ones a custom
+// ExceptionProvider would provide.
+func WithSampling(rules ...SamplingRule) Option {
+	return func(c *core) error {
+		c.samplingRules = append(c.samplingRules, rules...)
+		return nil
+	}
+}
+
+// WithDeduplication suppresses events identical to ones sent within the window.
+// Events are identical if they have the same message, level, caller and error type.
+// When the window closes, a follow-up event reports the number of suppressed repeats.
+// Syncing or closing the core closes all the windows, so the repeats aren't lost on exit.
+// At most size recently sent events are remembered.
+// Unlike zap's sampler, this only affects sentry, other cores still get all the entries.
+func WithDeduplication(window time.Duration, size int) Option {
+	return func(c *core) error {
+		if window <= 0 || size <= 0 {
+			return errors.New("deduplication window and size must be positive")
+		}
+		c.dedupWindow = window
+		c.dedupSize = size
+		return nil
+	}
+}
+
+// WithRateLimit limits the rate of events sent to sentry with a global token bucket and
+// per-key token buckets. The number of dropped events is reported periodically in a
+// summary event.
+func WithRateLimit(rl RateLimit) Option {
+	return func(c *core) error {
+		c.rateLimitConfig = &rl
+		return nil
+	}
+}
+
 func UseHub(hub *sentry.Hub) Option {
 	return func(c *core) error {
 		c.sentryHub = hub
@@ -120,3 +300,46 @@ func ConvertFieldsToTags(keys ...string) Option {
 		return nil
 	}
 }
+
+// ConvertFieldsToUser fills the event user from the fields with the declared keys, so
+// sentry can count and search the affected users.
+func ConvertFieldsToUser(keys UserKeys) Option {
+	return func(c *core) error {
+		c.events.userKeys = keys
+		return nil
+	}
+}
+
+// MapFieldsToTags converts fields to tags with different names, e.g. the method field to the
+// http.method tag. The mapping keys are field keys or dot separated paths into object
+// fields, e.g. request.user.tier, and the values are the tag names.
+func MapFieldsToTags(mapping map[string]string) Option {
+	return func(c *core) error {
+		for path, tag := range mapping {
+			if path == "" || tag == "" {
+				return errors.New("field paths and tag names can't be empty")
+			}
+			c.events.tagMappings[path] = tag
+		}
+		return nil
+	}
+}
+
+// WithScrubbing scrubs sensitive data from events and breadcrumbs before they are sent.
+// Values of sensitive keys in extra, contexts, tags, breadcrumb data and the Request field
+// are scrubbed, and sensitive values like emails and tokens are detected in all strings,
+// messages included. Values of identifier keys, including the user's, are replaced with
+// their keyed HMAC.
+// Sentry adds the data of the hub's scope after scrubbing, so requests should be logged
+// with the Request field instead of being set with sentry.Scope.SetRequest.
+func WithScrubbing(cfg ScrubConfig) Option {
+	return func(c *core) error {
+		s, err := newScrubber(cfg)
+		if err != nil {
+			return err
+		}
+		c.events.scrubber = s
+		c.breadcrumbs.scrubber = s
+		return nil
+	}
+}
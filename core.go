@@ -1,7 +1,9 @@
 package zapsentry
 
 import (
+	"context"
 	"errors"
+	"io"
 	"time"
 
 	"github.com/getsentry/sentry-go"
@@ -14,14 +16,19 @@ const (
 
 	zapSentryScopeKey = "_zapsentry_scope_"
 	zapSentryHubKey   = "_zapsentry_hub_"
+	zapSentryCtxKey   = "_zapsentry_context_"
 )
 
-var _ zapcore.Core = (*core)(nil)
+var (
+	_ zapcore.Core = (*core)(nil)
+	_ io.Closer    = (*core)(nil)
+)
 
 type core struct {
 	zapcore.LevelEnabler
 
-	level        zapcore.Level
+	// level enables events, it's either a static level or a dynamic one like zap.AtomicLevel.
+	level        zapcore.LevelEnabler
 	flushTimeout time.Duration
 
 	events      *events
@@ -31,9 +38,43 @@ type core struct {
 	sentryHub   *sentry.Hub
 	sentryScope *sentry.Scope
 
+	// asyncConfig configures the async queue, nil if events are sent synchronously.
+	asyncConfig *AsyncConfig
+	// async is the queue events are sent through, nil if events are sent synchronously.
+	async *asyncQueue
+
+	// ignoreRules declare entries which don't become events.
+	ignoreRules []IgnoreRule
+
+	// samplingRules configure the sampler, nil if events aren't sampled.
+	samplingRules []SamplingRule
+	// sampler samples events, nil if events aren't sampled.
+	sampler *sampler
+
+	// dedupWindow is the window within which identical events are suppressed, 0 if events
+	// aren't deduplicated.
+	dedupWindow time.Duration
+	// dedupSize is the maximum number of recently sent events remembered.
+	dedupSize int
+	// deduplicator suppresses identical events, nil if events aren't deduplicated.
+	deduplicator *deduplicator
+
+	// rateLimitConfig configures the rate limiter, nil if events aren't rate limited.
+	rateLimitConfig *RateLimit
+	// rateLimiter limits the rate of events, nil if events aren't rate limited.
+	rateLimiter *rateLimiter
+
+	// clock is the time source of deduplication windows and rate limits.
+	clock clock
+
 	fields map[string]interface{}
+	// contextFields are the fields added with With, kept with their original types.
+	contextFields []zapcore.Field
 }
 
+// NewCore returns a new zapsentry core.
+// The core implements io.Closer, closing it stops the goroutines it started for async
+// sending and rate limiting.
 func NewCore(factory SentryClientFactory, opts ...Option) (zapcore.Core, error) {
 	client, err := factory()
 	if err != nil {
@@ -48,6 +89,7 @@ func NewCore(factory SentryClientFactory, opts ...Option) (zapcore.Core, error)
 		fields:       make(map[string]interface{}),
 		breadcrumbs:  newBreadcrumbs(),
 		events:       newEvents(),
+		clock:        systemClock{},
 	}
 	for _, o := range opts {
 		err := o(core)
@@ -56,7 +98,8 @@ func NewCore(factory SentryClientFactory, opts ...Option) (zapcore.Core, error)
 		}
 	}
 
-	if core.breadcrumbs.enabled && core.breadcrumbs.level > core.level {
+	// Dynamic levels are validated by their current value.
+	if core.breadcrumbs.enabled && levelOf(core.breadcrumbs.level) > levelOf(core.level) {
 		return zapcore.NewNopCore(), errors.New("breadcrumb level must be lower than error level")
 	}
 	core.LevelEnabler = &LevelEnabler{
@@ -65,9 +108,44 @@ func NewCore(factory SentryClientFactory, opts ...Option) (zapcore.Core, error)
 	}
 
 	if !core.events.disabledStacktrace {
-		core.events.exceptionProvider = NewExceptionProvider(core.events.stackTraceFrameFilter)
+		exceptionProvider := NewExceptionProvider(
+			core.events.stackTraceFrameFilter,
+			core.events.stacktraceExtractors...,
+		)
+		exceptionProvider.useEntryStack = core.events.useEntryStacktrace
+		core.events.exceptionProvider = exceptionProvider
+	}
+
+	if core.samplingRules != nil {
+		core.sampler, err = newSampler(core.samplingRules, core.events.normalizer, core.events.fingerprinter)
+		if err != nil {
+			return zapcore.NewNopCore(), err
+		}
+	}
+
+	if core.dedupWindow != 0 {
+		core.deduplicator = newDeduplicator(core.dedupWindow, core.dedupSize, core.clock, core.send)
+	}
+
+	if core.rateLimitConfig != nil {
+		core.rateLimiter, err = newRateLimiter(*core.rateLimitConfig, core.events.normalizer, core.clock)
+		if err != nil {
+			return zapcore.NewNopCore(), err
+		}
 	}
 
+	if core.asyncConfig != nil {
+		core.async, err = newAsyncQueue(*core.asyncConfig)
+		if err != nil {
+			return zapcore.NewNopCore(), err
+		}
+		core.events.deferStacktraces = true
+	}
+
+	// Goroutines are started last, so they don't leak when the configuration is invalid.
+	if core.rateLimiter != nil {
+		go core.rateLimiter.run(core.events, core.hub)
+	}
 	return core, nil
 }
 
@@ -76,24 +154,45 @@ func (c *core) With(fs []zapcore.Field) zapcore.Core {
 }
 
 func (c *core) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
-	if c.LevelEnabler.Enabled(ent.Level) {
-		return ce.AddCore(ent, c)
+	if !c.LevelEnabler.Enabled(ent.Level) {
+		return ce
+	}
+	// Skip entries which are never sent and aren't breadcrumbs before paying for them.
+	if c.sampler != nil && c.sampler.rate(ent) <= 0 && !c.breadcrumbs.Enabled(ent.Level) {
+		return ce
 	}
-	return ce
+	return ce.AddCore(ent, c)
 }
 
 func (c *core) Write(ent zapcore.Entry, fs []zapcore.Field) error {
 	clone := c.with(fs)
+	// The entry's fields can carry a local scope, like zapsentry.Context does.
+	localScope := c.sentryScope != nil || hasScope(fs)
+
+	// Events are built from the context fields too, followed by the fields of this entry.
+	fs = clone.contextFields
+	event := c.level.Enabled(ent.Level)
+
+	// Entries matching an ignore rule don't become events, and become breadcrumbs only if
+	// the rule keeps them.
+	keepBreadcrumb := true
+	if event {
+		if rule, ok := ignored(c.ignoreRules, ent, fs); ok {
+			event, keepBreadcrumb = false, rule.KeepBreadcrumb
+		}
+	}
 
 	// only when we have local sentryScope to avoid collecting all breadcrumbs ever in a global scope
-	if c.breadcrumbs.Enabled(ent.Level) && c.sentryScope != nil {
+	if keepBreadcrumb && c.breadcrumbs.Enabled(ent.Level) && localScope {
 		breadcrumb := c.breadcrumbs.new(ent, clone.fields)
 		clone.hub().Scope().AddBreadcrumb(breadcrumb, maxLimit)
 	}
 
-	if c.level.Enabled(ent.Level) {
-		event := c.events.new(ent, fs, clone.fields)
-		_ = clone.hub().CaptureEvent(event)
+	if event && (c.sampler == nil || c.sampler.sample(ent, fs)) {
+		// Events are always built on the logging goroutine, only capturing them is async.
+		if d := c.events.build(clone.hub(), ent, fs, clone.fields); d != nil && c.allow(ent, fs, d) {
+			c.send(d)
+		}
 	}
 
 	// We may be crashing the program, so should flush any buffered events.
@@ -105,17 +204,69 @@ func (c *core) Write(ent zapcore.Entry, fs []zapcore.Field) error {
 	return nil
 }
 
+// send captures the event, through the async queue if events are sent asynchronously.
+func (c *core) send(d *draft) {
+	if c.async != nil {
+		c.async.enqueue(d)
+	} else {
+		d.capture()
+	}
+}
+
+// allow returns true if the built event for the entry should be sent.
+// Events are sampled before they are built. Repeats of recently sent events are suppressed
+// first, so they don't consume the rate limit.
+func (c *core) allow(ent zapcore.Entry, fs []zapcore.Field, d *draft) bool {
+	if c.deduplicator != nil && !c.deduplicator.allow(ent, fs, d) {
+		return false
+	}
+	return c.rateLimiter == nil || c.rateLimiter.allow(ent)
+}
+
+// Sync reports the repeats of deduplicated events and sends the rate limit summary of the
+// events dropped since the last one, waits for the queued events to be sent and flushes the
+// sentry client, within the flush timeout.
 func (c *core) Sync() error {
-	c.client.Flush(c.flushTimeout)
+	if c.deduplicator != nil {
+		c.deduplicator.flush()
+	}
+	if c.rateLimiter != nil {
+		c.rateLimiter.reportDropped(c.events, c.hub)
+	}
+
+	timeout := c.flushTimeout
+	if c.async != nil {
+		start := time.Now()
+		c.async.drain(timeout)
+		timeout -= time.Since(start)
+	}
+	c.client.Flush(timeout)
 	return nil
 }
 
+// Close syncs the core and stops it's goroutines. Clones share the goroutines, so closing
+// one closes all of them. Events logged after closing are sent synchronously.
+func (c *core) Close() error {
+	if c.rateLimiter != nil {
+		c.rateLimiter.close()
+	}
+	err := c.Sync()
+	if c.async != nil {
+		c.async.close()
+	}
+	return err
+}
+
 func (c *core) findScope(fs []zapcore.Field) *sentry.Scope {
 	for _, f := range fs {
 		if s := getScope(f); s != nil {
 			return s
 		}
 	}
+	// A hub carried by a context is request scoped, so it's scope is local.
+	if h := findContextHub(fs); h != nil {
+		return h.Scope()
+	}
 	return c.scope()
 }
 
@@ -125,9 +276,36 @@ func (c *core) findHub(fs []zapcore.Field) (*sentry.Hub, bool) {
 			return h, true
 		}
 	}
+	if h := findContextHub(fs); h != nil {
+		return h, true
+	}
 	return c.hub(), false
 }
 
+// hasScope returns true if one of the fields carries a scope, a hub or a context with a hub.
+func hasScope(fs []zapcore.Field) bool {
+	for _, f := range fs {
+		if getScope(f) != nil || getHub(f) != nil {
+			return true
+		}
+	}
+	return findContextHub(fs) != nil
+}
+
+// findContextHub returns the hub of the first context field carrying a hub.
+func findContextHub(fs []zapcore.Field) *sentry.Hub {
+	for _, f := range fs {
+		ctx := getContext(f)
+		if ctx == nil {
+			continue
+		}
+		if h := sentry.GetHubFromContext(ctx); h != nil {
+			return h
+		}
+	}
+	return nil
+}
+
 func getScope(field zapcore.Field) *sentry.Scope {
 	if field.Type == zapcore.SkipType && field.Key == zapSentryScopeKey {
 		if scope, ok := field.Interface.(*sentry.Scope); ok {
@@ -146,6 +324,15 @@ func getHub(field zapcore.Field) *sentry.Hub {
 	return nil
 }
 
+func getContext(field zapcore.Field) context.Context {
+	if field.Type == zapcore.SkipType && field.Key == zapSentryCtxKey {
+		if ctx, ok := field.Interface.(context.Context); ok {
+			return ctx
+		}
+	}
+	return nil
+}
+
 func (c *core) hub() *sentry.Hub {
 	if c.sentryHub != nil {
 		return c.sentryHub
@@ -178,6 +365,11 @@ func (c *core) with(fs []zapcore.Field) *core {
 		m[k] = v
 	}
 
+	// Copy the context fields, so clones don't share the backing array.
+	contextFields := make([]zapcore.Field, 0, len(c.contextFields)+len(fs))
+	contextFields = append(contextFields, c.contextFields...)
+	contextFields = append(contextFields, fs...)
+
 	scope := c.findScope(fs)
 	hub, found := c.findHub(fs)
 	if !found {
@@ -188,15 +380,21 @@ func (c *core) with(fs []zapcore.Field) *core {
 	}
 
 	return &core{
-		LevelEnabler: c.LevelEnabler,
-		breadcrumbs:  c.breadcrumbs,
-		events:       c.events,
-		client:       c.client,
-		sentryScope:  scope,
-		sentryHub:    hub,
-		level:        c.level,
-		flushTimeout: c.flushTimeout,
-		fields:       m,
+		LevelEnabler:  c.LevelEnabler,
+		breadcrumbs:   c.breadcrumbs,
+		events:        c.events,
+		client:        c.client,
+		sentryScope:   scope,
+		sentryHub:     hub,
+		async:         c.async,
+		ignoreRules:   c.ignoreRules,
+		sampler:       c.sampler,
+		deduplicator:  c.deduplicator,
+		rateLimiter:   c.rateLimiter,
+		level:         c.level,
+		flushTimeout:  c.flushTimeout,
+		fields:        m,
+		contextFields: contextFields,
 	}
 }
 
@@ -220,3 +418,13 @@ func WrapScope(scope *sentry.Scope) zapcore.Field {
 	f.Key = zapSentryScopeKey
 	return f
 }
+
+// Context wraps a context.Context. If the context carries a sentry hub, set with
+// sentry.SetHubOnContext, events and breadcrumbs are sent to that hub and it's scope.
+// Explicitly wrapped hubs and scopes take precedence over it.
+func Context(ctx context.Context) zapcore.Field {
+	f := zap.Skip()
+	f.Interface = ctx
+	f.Key = zapSentryCtxKey
+	return f
+}
@@ -0,0 +1,1273 @@
+package zapsentry_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/l2cup/zapsentry"
+)
+
+// newRecordingLogger returns a logger with a zapsentry core built with the passed options
+// and a function returning the events sent to sentry.
+func newRecordingLogger(t *testing.T, opts ...zapsentry.Option) (*zap.Logger, func() []*sentry.Event) {
+	t.Helper()
+	var mu sync.Mutex
+	var events []*sentry.Event
+	client := mockSentryClient(func(event *sentry.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, event)
+	})
+
+	core, err := zapsentry.NewCore(zapsentry.NewSentryClientFromClient(client), opts...)
+	if err != nil {
+		t.Fatalf("failed to create core: %v", err)
+	}
+	t.Cleanup(func() { _ = core.(io.Closer).Close() })
+	return zap.New(core), func() []*sentry.Event {
+		mu.Lock()
+		defer mu.Unlock()
+		return events
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	logger, events := newRecordingLogger(t,
+		zapsentry.Level(zapcore.ErrorLevel),
+		zapsentry.WithFingerprinter(&zapsentry.MessageFingerprinter{}),
+	)
+
+	logger.Named("cache").Error("miss")
+	logger.Error("miss", zapsentry.Fingerprint("custom"))
+
+	got := events()
+	if len(got) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(got))
+	}
+	if want := []string{"cache", "miss"}; !reflect.DeepEqual(got[0].Fingerprint, want) {
+		t.Errorf("expected fingerprint %v, got %v", want, got[0].Fingerprint)
+	}
+	if want := []string{"custom"}; !reflect.DeepEqual(got[1].Fingerprint, want) {
+		t.Errorf("expected fingerprint %v, got %v", want, got[1].Fingerprint)
+	}
+}
+
+func TestNormalizeMessages(t *testing.T) {
+	logger, events := newRecordingLogger(t,
+		zapsentry.NormalizeMessages(zapsentry.NormalizationRule{
+			Pattern:     regexp.MustCompile(`tenant-\w+`),
+			Replacement: "<tenant>",
+		}),
+	)
+
+	msg := "user 42 of tenant-acme not found after 1.5s from 10.0.0.1"
+	logger.Error(msg)
+
+	got := events()
+	if len(got) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(got))
+	}
+	if want := "user <num> of <tenant> not found after <duration> from <ip>"; got[0].Message != want {
+		t.Errorf("expected message %q, got %q", want, got[0].Message)
+	}
+	if got[0].Extra["original_message"] != msg {
+		t.Errorf("expected the original message in extra, got %v", got[0].Extra)
+	}
+}
+
+func TestNormalizeQuotes(t *testing.T) {
+	normalizer := zapsentry.NewMessageNormalizer()
+	for msg, want := range map[string]string{
+		`can't connect to db, won't retry`:        `can't connect to db, won't retry`,
+		`user's session expired`:                  `user's session expired`,
+		`the users' sessions expired`:             `the users' sessions expired`,
+		`can't find user 'jane'`:                  `can't find user <string>`,
+		`user's key 'a\'b' not found`:             `user's key <string> not found`,
+		`key 'jane' isn't "admin"`:                `key <string> isn't <string>`,
+		`'quoted' at the start`:                   `<string> at the start`,
+		`the driver's error: 'connection reset'.`: `the driver's error: <string>.`,
+	} {
+		if got := normalizer.Normalize(msg); got != want {
+			t.Errorf("expected %q normalized to %q, got %q", msg, want, got)
+		}
+	}
+}
+
+func TestContextHub(t *testing.T) {
+	logger, events := newRecordingLogger(t, zapsentry.WithBreadcrumbs(zapcore.InfoLevel))
+
+	hub := sentry.CurrentHub().Clone()
+	hub.Scope().SetTag("request_id", "42")
+	ctx := sentry.SetHubOnContext(context.Background(), hub)
+
+	requestLogger := logger.With(zapsentry.Context(ctx))
+	requestLogger.Info("handling request")
+	requestLogger.Error("request failed")
+
+	got := events()
+	if len(got) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(got))
+	}
+	if got[0].Tags["request_id"] != "42" {
+		t.Errorf("expected the event to be captured by the context hub, got tags %v", got[0].Tags)
+	}
+	if len(got[0].Breadcrumbs) == 0 || got[0].Breadcrumbs[0].Message != "handling request" {
+		t.Errorf("expected the breadcrumb in the context hub scope, got %v", got[0].Breadcrumbs)
+	}
+}
+
+func TestContextHubPerCall(t *testing.T) {
+	logger, events := newRecordingLogger(t, zapsentry.WithBreadcrumbs(zapcore.InfoLevel))
+
+	hub := sentry.CurrentHub().Clone()
+	hub.Scope().SetTag("request_id", "42")
+	ctx := sentry.SetHubOnContext(context.Background(), hub)
+
+	logger.Info("handling request", zapsentry.Context(ctx))
+	logger.Error("request failed", zapsentry.Context(ctx))
+
+	got := events()
+	if len(got) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(got))
+	}
+	if got[0].Tags["request_id"] != "42" {
+		t.Errorf("expected the event to be captured by the context hub, got tags %v", got[0].Tags)
+	}
+	if len(got[0].Breadcrumbs) == 0 || got[0].Breadcrumbs[0].Message != "handling request" {
+		t.Errorf("expected the breadcrumb in the context hub scope, got %v", got[0].Breadcrumbs)
+	}
+}
+
+func TestLogPanicStack(t *testing.T) {
+	logger, events := newRecordingLogger(t)
+	observed, logs := observer.New(zapcore.DebugLevel)
+	logger = zap.New(zapcore.NewTee(logger.Core(), observed), zap.AddStacktrace(zapcore.FatalLevel))
+
+	func() {
+		defer func() { zapsentry.LogPanic(logger, recover()) }()
+		panicking()
+	}()
+
+	if got := len(events()); got != 1 {
+		t.Fatalf("expected 1 event, got %d", got)
+	}
+	entries := logs.All()
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry, got %d", len(entries))
+	}
+	// The stack is formatted like zap's, from the panic site to the last non-runtime frame.
+	lines := strings.Split(entries[0].Stack, "\n")
+	if len(lines) < 4 || lines[0] != "github.com/l2cup/zapsentry_test.panicking" ||
+		lines[len(lines)-2] != "testing.tRunner" {
+		t.Errorf("expected the stack from the panic site to testing.tRunner, got\n%s", entries[0].Stack)
+	}
+}
+
+func panicking() { panic("boom") }
+
+func TestAsync(t *testing.T) {
+	logger, events := newRecordingLogger(t, zapsentry.WithAsync(zapsentry.AsyncConfig{Workers: 2}))
+
+	for i := 0; i < 10; i++ {
+		logger.Error("something went wrong")
+	}
+	_ = logger.Sync()
+
+	got := events()
+	if len(got) != 10 {
+		t.Fatalf("expected 10 events, got %d", len(got))
+	}
+	frames := got[0].Exception[0].Stacktrace.Frames
+	if len(frames) == 0 || frames[len(frames)-1].Function != "TestAsync" {
+		t.Errorf("expected the stack trace of the call site, got %+v", frames)
+	}
+}
+
+func TestAsyncQueueFull(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		config zapsentry.AsyncConfig
+		want   []string
+	}{
+		{"drop newest", zapsentry.AsyncConfig{DropPolicy: zapsentry.DropNewest}, []string{"1", "2"}},
+		{"drop oldest", zapsentry.AsyncConfig{DropPolicy: zapsentry.DropOldest}, []string{"1", "3"}},
+		{"block timeout", zapsentry.AsyncConfig{DropPolicy: zapsentry.Block, BlockTimeout: time.Millisecond}, []string{"1", "2"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.QueueSize, tt.config.Workers = 1, 1
+			logger, events, sending, release := newBlockingLogger(t, zapsentry.WithAsync(tt.config))
+
+			// The worker blocks sending the first event, the second one fills the queue.
+			logger.Error("1")
+			<-sending
+			logger.Error("2")
+			logger.Error("3")
+			release()
+			_ = logger.Sync()
+
+			var got []string
+			for _, event := range events() {
+				got = append(got, event.Message)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected events %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestAsyncQueueFullBlock(t *testing.T) {
+	logger, events, sending, release := newBlockingLogger(t, zapsentry.WithAsync(zapsentry.AsyncConfig{
+		QueueSize:    1,
+		Workers:      1,
+		DropPolicy:   zapsentry.Block,
+		BlockTimeout: time.Minute,
+	}))
+
+	logger.Error("1")
+	<-sending
+	logger.Error("2")
+	// Logging blocks until the worker makes room in the queue.
+	time.AfterFunc(10*time.Millisecond, release)
+	logger.Error("3")
+	_ = logger.Sync()
+
+	if got := len(events()); got != 3 {
+		t.Errorf("expected 3 events, got %d", got)
+	}
+}
+
+func TestAsyncSyncTimeout(t *testing.T) {
+	logger, events, sending, release := newBlockingLogger(t,
+		zapsentry.WithAsync(zapsentry.AsyncConfig{}),
+		zapsentry.WithFlushTimeout(10*time.Millisecond),
+	)
+
+	logger.Error("1")
+	<-sending
+	// Sync gives up waiting for the blocked event after the flush timeout.
+	_ = logger.Sync()
+	if got := len(events()); got != 0 {
+		t.Errorf("expected no events before the transport is released, got %d", got)
+	}
+
+	release()
+	_ = logger.Sync()
+	if got := len(events()); got != 1 {
+		t.Errorf("expected 1 event after the transport is released, got %d", got)
+	}
+}
+
+// newBlockingLogger returns a logger like newRecordingLogger, which transport blocks until
+// released. Every event signals sending when the transport gets it.
+func newBlockingLogger(
+	t *testing.T,
+	opts ...zapsentry.Option,
+) (logger *zap.Logger, events func() []*sentry.Event, sending <-chan struct{}, release func()) {
+	t.Helper()
+	var mu sync.Mutex
+	var sent []*sentry.Event
+	signal := make(chan struct{}, 100)
+	released := make(chan struct{})
+	client := mockSentryClient(func(event *sentry.Event) {
+		signal <- struct{}{}
+		<-released
+		mu.Lock()
+		defer mu.Unlock()
+		sent = append(sent, event)
+	})
+
+	core, err := zapsentry.NewCore(zapsentry.NewSentryClientFromClient(client), opts...)
+	if err != nil {
+		t.Fatalf("failed to create core: %v", err)
+	}
+	var once sync.Once
+	release = func() { once.Do(func() { close(released) }) }
+	// Cleanups run last first, so the transport is released before the core is closed.
+	t.Cleanup(func() { _ = core.(io.Closer).Close() })
+	t.Cleanup(release)
+	return zap.New(core), func() []*sentry.Event {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]*sentry.Event(nil), sent...)
+	}, signal, release
+}
+
+// fakeClock is a clock which only moves when advanced.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	at   time.Time
+	f    func()
+	done bool
+}
+
+func newFakeClock() *fakeClock { return &fakeClock{now: time.Unix(0, 0)} }
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) func() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	timer := &fakeTimer{at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, timer)
+	return func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		stopped := !timer.done
+		timer.done = true
+		return stopped
+	}
+}
+
+// Advance moves the clock and calls the functions of the timers which fired.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	var fired []func()
+	for _, timer := range c.timers {
+		if !timer.done && !timer.at.After(c.now) {
+			timer.done = true
+			fired = append(fired, timer.f)
+		}
+	}
+	c.mu.Unlock()
+	for _, f := range fired {
+		f()
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	clock := newFakeClock()
+	logger, events := newRecordingLogger(t,
+		zapsentry.WithClock(clock),
+		zapsentry.WithRateLimit(zapsentry.RateLimit{
+			Key:             zapsentry.RateLimitByMessage,
+			KeyRate:         1,
+			KeyBurst:        2,
+			SummaryInterval: time.Hour,
+		}),
+	)
+
+	for i := 0; i < 5; i++ {
+		logger.Error(fmt.Sprintf("user %d not found", i))
+	}
+	logger.Error("cache miss")
+
+	if got := len(events()); got != 3 {
+		t.Fatalf("expected 3 events, got %d", got)
+	}
+	// The bucket refills a token every second.
+	clock.Advance(time.Second)
+	logger.Error("user 5 not found")
+	logger.Error("user 6 not found")
+	if got := len(events()); got != 4 {
+		t.Fatalf("expected the refilled token to allow 1 event, got %d events", got)
+	}
+
+	// Sync sends the summary without waiting for the summary interval.
+	_ = logger.Sync()
+	got := events()
+	if len(got) != 5 {
+		t.Fatalf("expected a summary event, got %d events", len(got))
+	}
+	if got[4].Extra["user <num> not found"] != 4 {
+		t.Errorf("expected 4 dropped events in the summary, got %v", got[4].Extra)
+	}
+}
+
+func TestRateLimitEmptyKey(t *testing.T) {
+	logger, events := newRecordingLogger(t,
+		zapsentry.WithClock(newFakeClock()),
+		zapsentry.WithRateLimit(zapsentry.RateLimit{
+			Key:             zapsentry.RateLimitByLogger,
+			KeyRate:         1,
+			KeyBurst:        1,
+			SummaryInterval: time.Hour,
+		}),
+	)
+
+	// The root logger's empty name is a key like any other.
+	for i := 0; i < 10; i++ {
+		logger.Error("request failed")
+		logger.Named("api").Error("request failed")
+	}
+	_ = logger.Sync()
+
+	got := events()
+	if len(got) != 3 {
+		t.Fatalf("expected 2 events and a summary, got %d events", len(got))
+	}
+	want := map[string]interface{}{"<empty>": 9, "api": 9}
+	if !reflect.DeepEqual(got[2].Extra, want) {
+		t.Errorf("expected summary extra %v, got %v", want, got[2].Extra)
+	}
+}
+
+func TestRateLimitSummaryIsScrubbed(t *testing.T) {
+	logger, events := newRecordingLogger(t,
+		zapsentry.WithClock(newFakeClock()),
+		zapsentry.WithScrubbing(zapsentry.ScrubConfig{}),
+		zapsentry.WithRateLimit(zapsentry.RateLimit{
+			Key:             zapsentry.RateLimitByMessage,
+			KeyRate:         1,
+			KeyBurst:        1,
+			SummaryInterval: time.Hour,
+		}),
+	)
+
+	for _, email := range []string{"jane@example.com", "john@example.com"} {
+		logger.Error("login failed for " + email)
+		logger.Error("login failed for " + email)
+	}
+	_ = logger.Sync()
+
+	got := events()
+	if len(got) != 3 {
+		t.Fatalf("expected 2 events and a summary, got %d events", len(got))
+	}
+	want := map[string]interface{}{"login failed for [Filtered]": 2}
+	if !reflect.DeepEqual(got[2].Extra, want) {
+		t.Errorf("expected summary extra %v, got %v", want, got[2].Extra)
+	}
+}
+
+func TestDeduplication(t *testing.T) {
+	clock := newFakeClock()
+	logger, events := newRecordingLogger(t,
+		zapsentry.WithClock(clock),
+		zapsentry.WithDeduplication(time.Minute, 10),
+	)
+
+	for i := 0; i < 4; i++ {
+		logger.Error("connection reset", zap.Error(errors.New("EOF")))
+	}
+	logger.Error("another error")
+
+	if got := len(events()); got != 2 {
+		t.Fatalf("expected 2 events, got %d", got)
+	}
+
+	clock.Advance(time.Minute)
+	got := events()
+	if len(got) != 3 {
+		t.Fatalf("expected a follow-up event, got %d events", len(got))
+	}
+	if got[2].Message != "connection reset" || got[2].Extra["repeat_count"] != 3 {
+		t.Errorf("expected a follow-up reporting 3 repeats, got %q %v", got[2].Message, got[2].Extra)
+	}
+}
+
+func TestSampling(t *testing.T) {
+	logger, events := newRecordingLogger(t,
+		zapsentry.Level(zapcore.WarnLevel),
+		zapsentry.WithSampling(
+			zapsentry.SamplingRule{Level: zapcore.WarnLevel, Rate: 0},
+			zapsentry.SamplingRule{Level: zapcore.ErrorLevel, Logger: "cache", Rate: 0.5},
+		),
+	)
+
+	logger.Warn("slow query")
+	for i := 0; i < 100; i++ {
+		logger.Error(fmt.Sprintf("request %d failed", i))
+	}
+	if got := len(events()); got != 100 {
+		t.Fatalf("expected all 100 error events, got %d", got)
+	}
+
+	cache := logger.Named("cache")
+	sampled := 0
+	for i := 0; i < 100; i++ {
+		before := len(events())
+		cache.Error(fmt.Sprintf("miss %d", i))
+		cache.Error(fmt.Sprintf("miss %d", i))
+		switch len(events()) - before {
+		case 2:
+			sampled++
+		case 1:
+			t.Fatalf("expected consistent sampling of %q", fmt.Sprintf("miss %d", i))
+		}
+	}
+	if sampled < 25 || sampled > 75 {
+		t.Errorf("expected about half of the cache events sampled in, got %d", sampled)
+	}
+}
+
+func TestSamplingUsesFingerprinter(t *testing.T) {
+	logger, events := newRecordingLogger(t,
+		zapsentry.WithFingerprinter(&zapsentry.ErrorTypeFingerprinter{}),
+		zapsentry.WithSampling(zapsentry.SamplingRule{Level: zapcore.ErrorLevel, Rate: 0.5}),
+	)
+
+	// The errors are grouped by their type, so every event of a type is sampled the same way.
+	for i := 0; i < 100; i++ {
+		logger.Error(fmt.Sprintf("request %d failed", i), zap.Error(errors.New("EOF")))
+		logger.Error(fmt.Sprintf("request %d failed", i), zap.Error(fmt.Errorf("dial: %w", io.EOF)))
+	}
+
+	types := make(map[string]int)
+	for _, event := range events() {
+		types[event.Exception[len(event.Exception)-1].Type]++
+	}
+	for kind, n := range types {
+		if n != 100 {
+			t.Errorf("expected all or none of the %s events sampled in, got %d", kind, n)
+		}
+	}
+}
+
+func TestConvertFieldsToUser(t *testing.T) {
+	logger, events := newRecordingLogger(t,
+		zapsentry.ConvertFieldsToUser(zapsentry.UserKeys{ID: "user_id", Email: "user_email"}),
+	)
+
+	logger.With(zap.Int64("user_id", 42)).Error("payment failed",
+		zap.String("user_email", "jane@example.com"),
+	)
+	logger.Error("payment failed",
+		zap.Int64("user_id", 42),
+		zapsentry.User(sentry.User{ID: "7", Username: "john"}),
+	)
+
+	got := events()
+	if len(got) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(got))
+	}
+	if want := (sentry.User{ID: "42", Email: "jane@example.com"}); got[0].User != want {
+		t.Errorf("expected user %+v, got %+v", want, got[0].User)
+	}
+	if want := (sentry.User{ID: "7", Username: "john"}); got[1].User != want {
+		t.Errorf("expected user %+v, got %+v", want, got[1].User)
+	}
+}
+
+func TestEventFields(t *testing.T) {
+	logger, events := newRecordingLogger(t, zapsentry.WithTags(map[string]string{"region": "eu"}))
+
+	logger.Error("checkout failed",
+		zapsentry.Tag("region", "us"),
+		zapsentry.EventContext("cart", map[string]interface{}{"items": 3}),
+		zapsentry.Transaction("POST /checkout"),
+		zapsentry.EventLevel(sentry.LevelWarning),
+		zapsentry.Request(httptest.NewRequest(http.MethodPost, "http://example.com/checkout", nil)),
+	)
+
+	got := events()
+	if len(got) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(got))
+	}
+	event := got[0]
+	if event.Tags["region"] != "us" {
+		t.Errorf("expected the tag field to take precedence, got %v", event.Tags)
+	}
+	if event.Contexts["cart"] == nil {
+		t.Errorf("expected the cart context, got %v", event.Contexts)
+	}
+	if event.Transaction != "POST /checkout" {
+		t.Errorf("expected the transaction, got %q", event.Transaction)
+	}
+	if event.Level != sentry.LevelWarning {
+		t.Errorf("expected the level override, got %q", event.Level)
+	}
+	if event.Request == nil || event.Request.Method != http.MethodPost {
+		t.Errorf("expected the request, got %+v", event.Request)
+	}
+	if len(event.Extra) != 0 {
+		t.Errorf("expected no extra, got %v", event.Extra)
+	}
+}
+
+type tenant struct {
+	name string
+}
+
+func (t tenant) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("name", t.name)
+	return nil
+}
+
+func (t tenant) Tags() map[string]string { return map[string]string{"tenant": t.name} }
+
+func TestConvertContextFieldsToTags(t *testing.T) {
+	logger, events := newRecordingLogger(t, zapsentry.ConvertFieldsToTags("method", "tenant"))
+
+	requestLogger := logger.With(zap.String("method", "GET"), zap.Object("tenant", tenant{name: "acme"}))
+	requestLogger.Error("request failed")
+	requestLogger.Error("request failed", zap.String("method", "POST"))
+
+	got := events()
+	if len(got) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(got))
+	}
+	if want := map[string]string{"method": "GET", "tenant": "acme"}; !reflect.DeepEqual(got[0].Tags, want) {
+		t.Errorf("expected tags %v, got %v", want, got[0].Tags)
+	}
+	if got[1].Tags["method"] != "POST" {
+		t.Errorf("expected the logged field to take precedence, got %v", got[1].Tags)
+	}
+}
+
+func TestConvertScalarFieldsToTags(t *testing.T) {
+	logger, events := newRecordingLogger(t, zapsentry.ConvertFieldsToTags(
+		"attempt", "retry", "timeout", "ratio", "ip", "at", "long", "invalid key",
+	))
+
+	logger.Error("request failed",
+		zap.Int("attempt", 3),
+		zap.Bool("retry", true),
+		zap.Duration("timeout", 1500*time.Millisecond),
+		zap.Float64("ratio", 0.25),
+		zap.Stringer("ip", net.IPv4(10, 0, 0, 1)),
+		zap.Time("at", time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)),
+		zap.String("long", strings.Repeat("a", 300)),
+		zap.String("invalid key", "value"),
+	)
+
+	got := events()
+	if len(got) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(got))
+	}
+	want := map[string]string{
+		"attempt": "3",
+		"retry":   "true",
+		"timeout": "1.5s",
+		"ratio":   "0.25",
+		"ip":      "10.0.0.1",
+		"at":      "2021-10-01T12:00:00Z",
+		"long":    strings.Repeat("a", 200),
+	}
+	if !reflect.DeepEqual(got[0].Tags, want) {
+		t.Errorf("expected tags %v, got %v", want, got[0].Tags)
+	}
+	if want := []string{`tag key "invalid key" contains invalid characters`}; !reflect.DeepEqual(got[0].Extra["tag_errors"], want) {
+		t.Errorf("expected tag errors %v, got %v", want, got[0].Extra["tag_errors"])
+	}
+}
+
+type request struct {
+	tier string
+}
+
+func (r request) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	return enc.AddObject("user", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+		enc.AddString("tier", r.tier)
+		return nil
+	}))
+}
+
+func TestMapFieldsToTags(t *testing.T) {
+	logger, events := newRecordingLogger(t, zapsentry.MapFieldsToTags(map[string]string{
+		"method":            "http.method",
+		"verb":              "http.method",
+		"request.user.tier": "user.tier",
+	}))
+
+	logger.With(zap.Object("request", request{tier: "gold"})).Error("request failed",
+		zap.String("verb", "GET"),
+	)
+
+	got := events()
+	if len(got) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(got))
+	}
+	if want := map[string]string{"http.method": "GET", "user.tier": "gold"}; !reflect.DeepEqual(got[0].Tags, want) {
+		t.Errorf("expected tags %v, got %v", want, got[0].Tags)
+	}
+}
+
+func TestEnrichment(t *testing.T) {
+	region := "eu"
+	logger, events := newRecordingLogger(t,
+		zapsentry.WithTags(map[string]string{"region": "static"}),
+		zapsentry.WithEnrichment(func(_ zapcore.Entry, _ []zapcore.Field) zapsentry.Enrichment {
+			return zapsentry.Enrichment{
+				Tags:     map[string]string{"region": region},
+				Contexts: map[string]interface{}{"flags": map[string]bool{"new_checkout": true}},
+				Extra:    map[string]interface{}{"deploy": "v1", "attempt": 0},
+			}
+		}),
+	)
+
+	logger.Error("checkout failed", zap.Int("attempt", 2))
+	region = "us"
+	logger.Error("checkout failed", zapsentry.Tag("region", "override"))
+
+	got := events()
+	if len(got) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(got))
+	}
+	if got[0].Tags["region"] != "eu" || got[0].Contexts["flags"] == nil {
+		t.Errorf("expected the enrichment tags and contexts, got %v %v", got[0].Tags, got[0].Contexts)
+	}
+	if got[0].Extra["deploy"] != "v1" || got[0].Extra["attempt"] != int64(2) {
+		t.Errorf("expected the enrichment extra not to override fields, got %v", got[0].Extra)
+	}
+	if got[1].Tags["region"] != "override" {
+		t.Errorf("expected the tag field to take precedence, got %v", got[1].Tags)
+	}
+}
+
+func TestScrubbing(t *testing.T) {
+	logger, events := newRecordingLogger(t,
+		zapsentry.WithBreadcrumbs(zapcore.InfoLevel),
+		zapsentry.WithScrubbing(zapsentry.ScrubConfig{}),
+	)
+	ctx := sentry.SetHubOnContext(context.Background(), sentry.CurrentHub().Clone())
+	logger = logger.With(zapsentry.Context(ctx))
+
+	logger.Info("login attempt", zap.String("password", "hunter2"))
+	logger.Error("login failed for jane@example.com",
+		zap.String("authorization", "Basic amFuZTpodW50ZXIy"),
+		zap.String("client_secret", "s3cr3t"),
+		zap.String("card", "paid with 4111 1111 1111 1111"),
+		zap.String("order", "1234567890123"),
+	)
+
+	got := events()
+	if len(got) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(got))
+	}
+	event := got[0]
+	if event.Message != "login failed for [Filtered]" {
+		t.Errorf("expected the email to be masked, got %q", event.Message)
+	}
+	want := map[string]interface{}{
+		"authorization": "[Filtered]",
+		"client_secret": "[Filtered]",
+		"card":          "paid with [Filtered]",
+		"order":         "1234567890123",
+	}
+	if !reflect.DeepEqual(event.Extra, want) {
+		t.Errorf("expected extra %v, got %v", want, event.Extra)
+	}
+	if len(event.Breadcrumbs) == 0 || event.Breadcrumbs[0].Data["password"] != "[Filtered]" {
+		t.Errorf("expected the breadcrumb password to be masked, got %v", event.Breadcrumbs)
+	}
+}
+
+func TestScrubbingRequest(t *testing.T) {
+	logger, events := newRecordingLogger(t, zapsentry.WithScrubbing(zapsentry.ScrubConfig{
+		Keys: []string{"authorization", "token", "session", "password"},
+	}))
+
+	r := httptest.NewRequest(http.MethodPost, "http://example.com/login?token=abc&page=2", nil)
+	r.Header.Set("Authorization", "Bearer supersecret")
+	r.Header.Set("Cookie", "session=abc; theme=dark")
+	r.Header.Set("X-Forwarded-For", "jane@example.com")
+	request := zapsentry.Request(r)
+	logger.Error("login failed", request)
+	logger.Error("login failed again", request)
+
+	got := events()
+	if len(got) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(got))
+	}
+	req := got[1].Request
+	if req.Headers["Authorization"] != "[Filtered]" || req.Headers["X-Forwarded-For"] != "[Filtered]" {
+		t.Errorf("expected the headers to be scrubbed, got %v", req.Headers)
+	}
+	if want := "token=%5BFiltered%5D&page=2"; req.QueryString != want {
+		t.Errorf("expected query string %q, got %q", want, req.QueryString)
+	}
+	if want := "session=[Filtered]; theme=dark"; req.Cookies != want {
+		t.Errorf("expected cookies %q, got %q", want, req.Cookies)
+	}
+
+}
+
+func TestScrubbingRequestBody(t *testing.T) {
+	for _, tt := range []struct {
+		contentType, data, want string
+	}{
+		{"application/x-www-form-urlencoded", "user=jane&password=hunter2", "user=jane&password=%5BFiltered%5D"},
+		{"application/json", `{"user":"jane","password":"hunter2"}`, `{"password":"[Filtered]","user":"jane"}`},
+		{"text/plain", "jane@example.com", "[Filtered]"},
+	} {
+		tt := tt
+		// Hooks run before scrubbing, so the body they add is scrubbed too.
+		logger, events := newRecordingLogger(t,
+			zapsentry.WithScrubbing(zapsentry.ScrubConfig{}),
+			zapsentry.WithBeforeCapture(func(event *sentry.Event, _ zapcore.Entry, _ []zapcore.Field) *sentry.Event {
+				event.Request = &sentry.Request{
+					Data:    tt.data,
+					Headers: map[string]string{"Content-Type": tt.contentType},
+				}
+				return event
+			}),
+		)
+
+		logger.Error("login failed")
+
+		got := events()
+		if len(got) != 1 {
+			t.Fatalf("expected 1 event, got %d", len(got))
+		}
+		if got[0].Request.Data != tt.want {
+			t.Errorf("expected %s body %q, got %q", tt.contentType, tt.want, got[0].Request.Data)
+		}
+	}
+}
+
+func TestScrubbingDefaultKeys(t *testing.T) {
+	logger, events := newRecordingLogger(t, zapsentry.WithScrubbing(zapsentry.ScrubConfig{}))
+
+	logger.Error("request failed",
+		zap.String("access_token", "abc"),
+		zap.String("db_password", "hunter2"),
+		zap.String("X-Api-Token", "abc"),
+		zap.String("Set-Cookie", "session=abc"),
+		zap.String("proxy_authorization", "abc"),
+		zap.String("user_passwd", "hunter2"),
+		zap.String("path", "/login"),
+	)
+
+	got := events()
+	if len(got) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(got))
+	}
+	want := map[string]interface{}{
+		"access_token":        "[Filtered]",
+		"db_password":         "[Filtered]",
+		"X-Api-Token":         "[Filtered]",
+		"Set-Cookie":          "[Filtered]",
+		"proxy_authorization": "[Filtered]",
+		"user_passwd":         "[Filtered]",
+		"path":                "/login",
+	}
+	if !reflect.DeepEqual(got[0].Extra, want) {
+		t.Errorf("expected extra %v, got %v", want, got[0].Extra)
+	}
+}
+
+func TestScrubbingHashKeys(t *testing.T) {
+	secret := []byte("secret")
+	logger, events := newRecordingLogger(t,
+		zapsentry.ConvertFieldsToTags("account_id"),
+		zapsentry.ConvertFieldsToUser(zapsentry.UserKeys{ID: "user_id", Username: "login"}),
+		zapsentry.WithScrubbing(zapsentry.ScrubConfig{
+			HashKeys:   []string{"user_id", "account_*"},
+			HashSecret: secret,
+		}),
+	)
+
+	logger.Error("transfer failed",
+		zap.String("user_id", "42"),
+		zap.String("login", "jane"),
+		zap.String("account_id", "DE89370400440532013000"),
+	)
+
+	got := events()
+	if len(got) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(got))
+	}
+	hash := func(v string) string {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(v))
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+	event := got[0]
+	if event.Extra["user_id"] != hash("42") || event.User.ID != hash("42") {
+		t.Errorf("expected the user id to be hashed, got %v %+v", event.Extra, event.User)
+	}
+	if event.User.Username != "jane" {
+		t.Errorf("expected the username to be kept, got %+v", event.User)
+	}
+	if event.Tags["account_id"] != hash("DE89370400440532013000") ||
+		event.Extra["account_id"] != event.Tags["account_id"] {
+		t.Errorf("expected the account id to be hashed, got %v %v", event.Tags, event.Extra)
+	}
+}
+
+func TestScrubbingHashUserField(t *testing.T) {
+	secret := []byte("secret")
+	logger, events := newRecordingLogger(t, zapsentry.WithScrubbing(zapsentry.ScrubConfig{
+		HashKeys:   []string{"user_id", "ip_address"},
+		HashSecret: secret,
+	}))
+
+	// The user fields are matched by their names, without being mapped from other fields.
+	logger.Error("transfer failed", zapsentry.User(sentry.User{ID: "42", IPAddress: "10.0.0.1", Username: "jane"}))
+
+	got := events()
+	if len(got) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(got))
+	}
+	hash := func(v string) string {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(v))
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+	want := sentry.User{ID: hash("42"), IPAddress: hash("10.0.0.1"), Username: "jane"}
+	if got[0].User != want {
+		t.Errorf("expected user %+v, got %+v", want, got[0].User)
+	}
+}
+
+func TestScrubbingHashAction(t *testing.T) {
+	factory := zapsentry.NewSentryClientFromClient(&sentry.Client{})
+	if _, err := zapsentry.NewCore(factory, zapsentry.WithScrubbing(zapsentry.ScrubConfig{
+		Action: zapsentry.ScrubHash,
+	})); err == nil {
+		t.Error("expected an error for hashing without a secret")
+	}
+
+	secret := []byte("secret")
+	logger, events := newRecordingLogger(t, zapsentry.WithScrubbing(zapsentry.ScrubConfig{
+		Action:     zapsentry.ScrubHash,
+		HashSecret: secret,
+	}))
+
+	logger.Error("payment failed", zap.String("card", "4111 1111 1111 1111"))
+
+	got := events()
+	if len(got) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(got))
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("4111 1111 1111 1111"))
+	if want := hex.EncodeToString(mac.Sum(nil)); got[0].Extra["card"] != want {
+		t.Errorf("expected the card number HMAC %q, got %v", want, got[0].Extra["card"])
+	}
+}
+
+func TestBeforeCapture(t *testing.T) {
+	logger, events := newRecordingLogger(t, zapsentry.WithBeforeCapture(
+		func(event *sentry.Event, ent zapcore.Entry, fs []zapcore.Field) *sentry.Event {
+			for _, f := range fs {
+				if err, ok := f.Interface.(error); ok && errors.Is(err, context.Canceled) {
+					return nil
+				}
+			}
+			event.Message = strings.ToUpper(ent.Message)
+			return event
+		},
+	))
+
+	logger.Error("request failed", zap.Error(fmt.Errorf("query: %w", context.Canceled)))
+	logger.Error("request failed", zap.Error(errors.New("connection refused")))
+
+	got := events()
+	if len(got) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(got))
+	}
+	if got[0].Message != "REQUEST FAILED" {
+		t.Errorf("expected the hook to rewrite the message, got %q", got[0].Message)
+	}
+}
+
+func TestBeforeCaptureRunsBeforeLimits(t *testing.T) {
+	logger, events := newRecordingLogger(t,
+		zapsentry.WithClock(newFakeClock()),
+		zapsentry.WithDeduplication(time.Minute, 10),
+		zapsentry.WithRateLimit(zapsentry.RateLimit{Rate: 1, Burst: 1, SummaryInterval: time.Hour}),
+		zapsentry.WithBeforeCapture(
+			func(event *sentry.Event, ent zapcore.Entry, fs []zapcore.Field) *sentry.Event {
+				if event.Extra["drop"] == true {
+					return nil
+				}
+				return event
+			},
+		),
+	)
+
+	// Dropped events neither take the rate limit token nor a deduplication slot.
+	logger.Error("request failed", zap.Bool("drop", true))
+	logger.Error("request failed", zap.Bool("drop", false))
+	_ = logger.Sync()
+
+	got := events()
+	if len(got) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(got))
+	}
+	if got[0].Extra["drop"] != false {
+		t.Errorf("expected the kept event, got %v", got[0].Extra)
+	}
+}
+
+func TestIgnoreRules(t *testing.T) {
+	logger, events := newRecordingLogger(t,
+		zapsentry.Level(zapcore.WarnLevel),
+		zapsentry.WithBreadcrumbs(zapcore.InfoLevel),
+		zapsentry.WithIgnoreRules(
+			zapsentry.IgnoreRule{Errors: []error{context.Canceled}, KeepBreadcrumb: true},
+			zapsentry.IgnoreRule{ErrorTypes: []interface{}{new(*net.OpError)}},
+			zapsentry.IgnoreRule{Messages: []*regexp.Regexp{regexp.MustCompile(`^cache miss`)}},
+			zapsentry.IgnoreRule{Loggers: []string{"noisy"}, Messages: []*regexp.Regexp{regexp.MustCompile(`retry`)}},
+		),
+	)
+
+	hub := sentry.CurrentHub().Clone()
+	requestLogger := logger.With(zapsentry.Context(sentry.SetHubOnContext(context.Background(), hub)))
+
+	requestLogger.Error("query failed", zap.Error(fmt.Errorf("query: %w", context.Canceled)))
+	requestLogger.Error("dial failed", zap.Error(fmt.Errorf("dial: %w", &net.OpError{Op: "dial", Err: errors.New("refused")})))
+	requestLogger.Warn("cache miss for user 42")
+	requestLogger.Named("noisy").Warn("retry scheduled")
+	requestLogger.Named("other").Warn("retry scheduled")
+
+	got := events()
+	if len(got) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(got))
+	}
+	if got[0].Message != "retry scheduled" {
+		t.Errorf("expected the event of the other logger, got %q", got[0].Message)
+	}
+	var breadcrumbs []string
+	for _, b := range got[0].Breadcrumbs {
+		breadcrumbs = append(breadcrumbs, b.Message)
+	}
+	want := []string{"query failed", "retry scheduled"}
+	if !reflect.DeepEqual(breadcrumbs, want) {
+		t.Errorf("expected breadcrumbs %v, got %v", want, breadcrumbs)
+	}
+}
+
+func TestIgnoreRulesCallerPackages(t *testing.T) {
+	for _, opts := range [][]zap.Option{nil, {zap.AddCaller()}} {
+		logger, events := newRecordingLogger(t, zapsentry.WithIgnoreRules(
+			zapsentry.IgnoreRule{CallerPackages: []string{"github.com/l2cup/zapsentry_test"}, Loggers: []string{"ignored"}},
+			zapsentry.IgnoreRule{CallerPackages: []string{"net/http"}},
+		))
+		logger = logger.WithOptions(opts...)
+
+		logger.Named("ignored").Error("request failed")
+		logger.Error("request failed")
+
+		if got := len(events()); got != 1 {
+			t.Errorf("expected 1 event with options %v, got %d", opts, got)
+		}
+	}
+}
+
+func TestIgnoreRulesValidation(t *testing.T) {
+	factory := zapsentry.NewSentryClientFromClient(&sentry.Client{})
+	for _, rule := range []zapsentry.IgnoreRule{
+		{},
+		{ErrorTypes: []interface{}{net.OpError{}}},
+		{ErrorTypes: []interface{}{new(string)}},
+	} {
+		if _, err := zapsentry.NewCore(factory, zapsentry.WithIgnoreRules(rule)); err == nil {
+			t.Errorf("expected an error for rule %+v", rule)
+		}
+	}
+}
+
+func TestAtomicLevels(t *testing.T) {
+	level := zap.NewAtomicLevelAt(zapcore.ErrorLevel)
+	breadcrumbsLevel := zap.NewAtomicLevelAt(zapcore.WarnLevel)
+	logger, events := newRecordingLogger(t,
+		zapsentry.WithLevelEnabler(level),
+		zapsentry.WithBreadcrumbsLevelEnabler(breadcrumbsLevel),
+	)
+
+	hub := sentry.CurrentHub().Clone()
+	requestLogger := logger.With(zapsentry.Context(sentry.SetHubOnContext(context.Background(), hub)))
+
+	requestLogger.Info("dropped")
+	requestLogger.Warn("not sent")
+	breadcrumbsLevel.SetLevel(zapcore.InfoLevel)
+	requestLogger.Info("kept")
+	level.SetLevel(zapcore.WarnLevel)
+	requestLogger.Warn("sent")
+
+	got := events()
+	if len(got) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(got))
+	}
+	if got[0].Message != "sent" {
+		t.Errorf("expected the event sent after lowering the level, got %q", got[0].Message)
+	}
+	var breadcrumbs []string
+	for _, b := range got[0].Breadcrumbs {
+		breadcrumbs = append(breadcrumbs, b.Message)
+	}
+	want := []string{"not sent", "kept", "sent"}
+	if !reflect.DeepEqual(breadcrumbs, want) {
+		t.Errorf("expected breadcrumbs %v, got %v", want, breadcrumbs)
+	}
+}
+
+// account is a mutable object logged as a field.
+type account struct {
+	tier string
+}
+
+func (a *account) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("tier", a.tier)
+	return nil
+}
+
+func (a *account) Tags() map[string]string { return map[string]string{"tier": a.tier} }
+
+func TestAsyncReadsFieldsOnLoggingGoroutine(t *testing.T) {
+	logger, events := newRecordingLogger(t,
+		zapsentry.WithAsync(zapsentry.AsyncConfig{}),
+		zapsentry.ConvertFieldsToTags("account"),
+	)
+
+	a := &account{tier: "free"}
+	logger.Error("upgrade failed", zap.Object("account", a))
+	// Modifying the logged object after logging mustn't race with the worker.
+	a.tier = "pro"
+	_ = logger.Sync()
+
+	got := events()
+	if len(got) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(got))
+	}
+	if got[0].Tags["tier"] != "free" {
+		t.Errorf("expected the tags of the object when it was logged, got %v", got[0].Tags)
+	}
+	frames := got[0].Exception[0].Stacktrace.Frames
+	if len(frames) == 0 || frames[len(frames)-1].Function != "TestAsyncReadsFieldsOnLoggingGoroutine" {
+		t.Errorf("expected the stack trace of the call site, got %+v", frames)
+	}
+}
+
+func TestNewCoreErrorStopsGoroutines(t *testing.T) {
+	_, err := zapsentry.NewCore(
+		zapsentry.NewSentryClientFromClient(mockSentryClient(func(*sentry.Event) {})),
+		zapsentry.WithRateLimit(zapsentry.RateLimit{Rate: 1}),
+		zapsentry.WithAsync(zapsentry.AsyncConfig{QueueSize: -1}),
+	)
+	if err == nil {
+		t.Fatal("expected an error for the negative queue size")
+	}
+
+	// Goroutines of cores closed by other tests can take a moment to exit.
+	deadline := time.Now().Add(time.Second)
+	for {
+		buf := make([]byte, 1<<20)
+		stacks := string(buf[:runtime.Stack(buf, true)])
+		if !strings.Contains(stacks, "created by github.com/l2cup/zapsentry.NewCore") {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected no goroutines started by NewCore, got\n%s", stacks)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestClose(t *testing.T) {
+	var mu sync.Mutex
+	var events []*sentry.Event
+	client := mockSentryClient(func(event *sentry.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, event)
+	})
+	core, err := zapsentry.NewCore(zapsentry.NewSentryClientFromClient(client),
+		zapsentry.WithAsync(zapsentry.AsyncConfig{}),
+		zapsentry.WithRateLimit(zapsentry.RateLimit{Rate: 0.001, SummaryInterval: time.Hour}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := zap.New(core)
+
+	logger.Error("sent")
+	logger.Error("dropped")
+	if err := core.(io.Closer).Close(); err != nil {
+		t.Fatal(err)
+	}
+	// The queue is closed, so the event is captured synchronously, or it would panic.
+	logger.With(zap.String("after", "close")).Error("dropped after close")
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) != 2 {
+		t.Fatalf("expected the event and the summary, got %d events", len(events))
+	}
+	// The summary is sent before the queue is drained.
+	summary := events[0]
+	if summary.Message == "sent" {
+		summary = events[1]
+	}
+	if summary.Extra["global"] != 1 {
+		t.Errorf("expected the summary to be sent on close, got %q %v", summary.Message, summary.Extra)
+	}
+}
+
+func TestDeduplicationSync(t *testing.T) {
+	clock := newFakeClock()
+	logger, events := newRecordingLogger(t,
+		zapsentry.WithClock(clock),
+		zapsentry.WithDeduplication(time.Minute, 10),
+	)
+
+	logger.Error("connection reset")
+	logger.Error("connection reset")
+	// Entries above error level sync the core, like before a fatal entry exits.
+	logger.DPanic("invariant violated")
+
+	got := events()
+	if len(got) != 3 {
+		t.Fatalf("expected 2 events and a follow-up, got %d events", len(got))
+	}
+	if got[2].Message != "connection reset" || got[2].Extra["repeat_count"] != 1 {
+		t.Errorf("expected a follow-up reporting 1 repeat, got %q %v", got[2].Message, got[2].Extra)
+	}
+
+	// The windows are closed, so their timers don't report again.
+	clock.Advance(time.Minute)
+	if got := len(events()); got != 3 {
+		t.Errorf("expected no more events after the window, got %d events", got)
+	}
+}
+
+func TestDeduplicationEvictionIsAsync(t *testing.T) {
+	logger, events := newRecordingLogger(t,
+		zapsentry.WithClock(newFakeClock()),
+		zapsentry.WithDeduplication(time.Minute, 1),
+		zapsentry.WithAsync(zapsentry.AsyncConfig{}),
+	)
+
+	logger.Error("connection reset")
+	logger.Error("connection reset")
+	// Evicts the first event, which sends it's follow-up through the queue.
+	logger.Error("another error")
+	_ = logger.Sync()
+
+	got := events()
+	if len(got) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(got))
+	}
+	var repeats interface{}
+	for _, event := range got {
+		if n, ok := event.Extra["repeat_count"]; ok {
+			repeats = n
+		}
+	}
+	if repeats != 1 {
+		t.Errorf("expected a follow-up reporting 1 repeat, got %v", repeats)
+	}
+}
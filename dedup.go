@@ -0,0 +1,151 @@
+package zapsentry
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// repeatCountKey is the event extra key holding the number of suppressed repeats.
+const repeatCountKey = "repeat_count"
+
+// dedupSignature identifies identical events.
+type dedupSignature struct {
+	message   string
+	level     zapcore.Level
+	caller    string
+	errorType string
+}
+
+// dedupEntry is a recently sent event.
+type dedupEntry struct {
+	signature dedupSignature
+	// followUp is a copy of the sent event, captured as the follow-up event.
+	followUp *draft
+	// repeats is the number of suppressed repeats of the event.
+	repeats int
+	// stopTimer stops the timer closing the window.
+	stopTimer func() bool
+}
+
+// deduplicator suppresses repeats of recently sent events within a window, and reports the
+// number of repeats in a follow-up event when the window closes.
+// Recently sent events are kept in a bounded LRU.
+type deduplicator struct {
+	window time.Duration
+	size   int
+	clock  clock
+	// send captures follow-up events, through the async queue if there is one.
+	send    func(d *draft)
+	mu      sync.Mutex
+	recent  *list.List
+	entries map[dedupSignature]*list.Element
+}
+
+func newDeduplicator(window time.Duration, size int, clock clock, send func(d *draft)) *deduplicator {
+	return &deduplicator{
+		window:  window,
+		size:    size,
+		clock:   clock,
+		send:    send,
+		recent:  list.New(),
+		entries: make(map[dedupSignature]*list.Element, size),
+	}
+}
+
+// allow returns true if the event isn't a repeat of a recently sent one.
+func (d *deduplicator) allow(ent zapcore.Entry, fs []zapcore.Field, event *draft) bool {
+	signature := newDedupSignature(ent, fs)
+
+	d.mu.Lock()
+	if el, ok := d.entries[signature]; ok {
+		el.Value.(*dedupEntry).repeats++
+		d.recent.MoveToFront(el)
+		d.mu.Unlock()
+		return false
+	}
+
+	entry := &dedupEntry{
+		signature: signature,
+		followUp:  event.clone(),
+	}
+	d.entries[signature] = d.recent.PushFront(entry)
+	entry.stopTimer = d.clock.AfterFunc(d.window, func() { d.expire(entry) })
+
+	var evicted *dedupEntry
+	if d.recent.Len() > d.size {
+		evicted = d.remove(d.recent.Back())
+		evicted.stopTimer()
+	}
+	d.mu.Unlock()
+
+	if evicted != nil {
+		d.report(evicted)
+	}
+	return true
+}
+
+// expire closes the window of the entry.
+func (d *deduplicator) expire(entry *dedupEntry) {
+	d.mu.Lock()
+	el, ok := d.entries[entry.signature]
+	if !ok || el.Value.(*dedupEntry) != entry {
+		// Already evicted and reported.
+		d.mu.Unlock()
+		return
+	}
+	d.remove(el)
+	d.mu.Unlock()
+
+	d.report(entry)
+}
+
+// flush closes the windows of all the entries, reporting their repeats.
+func (d *deduplicator) flush() {
+	d.mu.Lock()
+	entries := make([]*dedupEntry, 0, d.recent.Len())
+	for d.recent.Len() > 0 {
+		entry := d.remove(d.recent.Back())
+		entry.stopTimer()
+		entries = append(entries, entry)
+	}
+	d.mu.Unlock()
+
+	for _, entry := range entries {
+		d.report(entry)
+	}
+}
+
+// remove removes the element from the LRU. The lock must be held.
+func (d *deduplicator) remove(el *list.Element) *dedupEntry {
+	entry := d.recent.Remove(el).(*dedupEntry)
+	delete(d.entries, entry.signature)
+	return entry
+}
+
+// report sends the follow-up event reporting the number of repeats, if there were any.
+func (d *deduplicator) report(entry *dedupEntry) {
+	if entry.repeats == 0 {
+		return
+	}
+
+	followUp := entry.followUp
+	followUp.event.Extra[repeatCountKey] = entry.repeats
+	followUp.event.Timestamp = d.clock.Now()
+	d.send(followUp)
+}
+
+// newDedupSignature returns the signature of the entry.
+func newDedupSignature(ent zapcore.Entry, fs []zapcore.Field) dedupSignature {
+	signature := dedupSignature{
+		message: ent.Message,
+		level:   ent.Level,
+		caller:  ent.Caller.String(),
+	}
+	if errs := errorsFromFields(fs); len(errs) > 0 {
+		signature.errorType = errorType(errs[0])
+	}
+	return signature
+}
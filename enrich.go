@@ -0,0 +1,56 @@
+package zapsentry
+
+import (
+	"github.com/getsentry/sentry-go"
+	"go.uber.org/zap/zapcore"
+)
+
+// Enrichment holds the attributes an EnrichmentFunc adds to an event.
+type Enrichment struct {
+	Tags     map[string]string
+	Contexts map[string]interface{}
+	Extra    map[string]interface{}
+}
+
+// EnrichmentFunc is called for every event with the entry and it's fields, and returns
+// attributes added to the event, e.g. the current feature flags or the active region.
+type EnrichmentFunc func(ent zapcore.Entry, fs []zapcore.Field) Enrichment
+
+// enrich adds the attributes returned by the enrichment functions to the event.
+// Tags override tags converted from fields and the ones set with WithTags. Contexts and
+// extra don't override the ones from fields.
+// Functions are called in order, so later ones override earlier ones.
+func enrich(event *sentry.Event, fns []EnrichmentFunc, ent zapcore.Entry, fs []zapcore.Field) {
+	if len(fns) == 0 {
+		return
+	}
+
+	contexts := make(map[string]interface{})
+	extra := make(map[string]interface{})
+	for _, fn := range fns {
+		enrichment := fn(ent, fs)
+		for k, v := range enrichment.Tags {
+			event.Tags[k] = v
+		}
+		for k, v := range enrichment.Contexts {
+			contexts[k] = v
+		}
+		for k, v := range enrichment.Extra {
+			extra[k] = v
+		}
+	}
+
+	for k, v := range contexts {
+		if _, ok := event.Contexts[k]; !ok {
+			event.Contexts[k] = v
+		}
+	}
+	if len(extra) == 0 {
+		return
+	}
+	// Extra has to be copied since it's shared with the breadcrumbs.
+	for k, v := range event.Extra {
+		extra[k] = v
+	}
+	event.Extra = extra
+}
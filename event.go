@@ -7,6 +7,11 @@ import (
 
 const defaultPlatform = "Golang"
 
+// BeforeCaptureFunc is called with the draft event, the entry and the fields it was built
+// from, before the event is captured. Unlike sentry's BeforeSend, it has access to the
+// original zap entry and fields. It can modify the event, or return nil to drop it.
+type BeforeCaptureFunc func(event *sentry.Event, ent zapcore.Entry, fs []zapcore.Field) *sentry.Event
+
 // Tagger allows adding custom tags on datatypes
 type Tagger interface {
 	// Tags returns custom defined tags for the type
@@ -17,10 +22,23 @@ type events struct {
 	environment       string
 	platform          string
 	registeredTagKeys map[string]byte
+	tagMappings       map[string]string
+	userKeys          UserKeys
 
 	disabledStacktrace    bool
 	stackTraceFrameFilter StacktraceFrameFilter
+	stacktraceExtractors  []StacktraceExtractor
+	useEntryStacktrace    bool
 	exceptionProvider     ExceptionProvider
+	// deferStacktraces is true if stack traces are symbolized when events are captured,
+	// instead of when they are built.
+	deferStacktraces bool
+
+	fingerprinter Fingerprinter
+	enrichments   []EnrichmentFunc
+	beforeCapture []BeforeCaptureFunc
+	scrubber      *scrubber
+	normalizer    *MessageNormalizer
 
 	tags map[string]string
 }
@@ -33,56 +51,197 @@ func newEvents() *events {
 		stackTraceFrameFilter: defaults.stackTraceFrameFilter,
 		exceptionProvider:     defaults.exceptionProvider,
 		registeredTagKeys:     make(map[string]byte),
+		tagMappings:           make(map[string]string),
 		tags:                  make(map[string]string),
 	}
 }
 
+// new returns a new event built from the entry, and the stack traces of it's exceptions.
+// The stack traces are symbolized unless symbolizing them is deferred.
 func (e *events) new(
 	ent zapcore.Entry,
 	fs []zapcore.Field,
 	extra map[string]interface{},
-) *sentry.Event {
+) (*sentry.Event, *stacktraces) {
+	ent, extra = e.normalize(ent, extra)
+	exceptions, traces := e.exceptions(ent, fs)
+	if !e.deferStacktraces {
+		traces.symbolize()
+	}
+
 	event := sentry.NewEvent()
 	event.Message = ent.Message
 	event.Timestamp = ent.Time
 	event.Level = zapToSentryLevel(ent.Level)
-	event.Extra = extra
+	// Capturing the event modifies extra, which is shared with the breadcrumbs.
+	event.Extra = copyExtra(extra)
 	event.Platform = e.platform
-	event.Exception = e.exceptionProvider.Exception(ent)
+	event.Exception = exceptions
+	event.Fingerprint = e.fingerprint(ent, fs, event.Exception)
+	event.User = e.userKeys.user(fs, extra)
 	if e.environment != "" {
 		event.Environment = e.environment
 	}
 
 	tags := e.tagsFromFields(fs)
+	for k, v := range tagsFromPaths(e.tagMappings, fs, extra) {
+		tags[k] = v
+	}
 	for k, v := range e.tags {
 		tags[k] = v
 	}
 	event.Tags = tags
-	return event
+	enrich(event, e.enrichments, ent, fs)
+	applyFields(event, fs)
+	if violations := validateTags(event.Tags); len(violations) > 0 {
+		event.Extra = withExtra(event.Extra, tagErrorsKey, violations)
+	}
+	return event, traces
+}
+
+// exceptions returns the exceptions of the entry, see events.new.
+// Only the stack traces of the DefaultExceptionProvider can be symbolized later.
+func (e *events) exceptions(ent zapcore.Entry, fs []zapcore.Field) ([]sentry.Exception, *stacktraces) {
+	if dep, ok := e.exceptionProvider.(*DefaultExceptionProvider); ok {
+		return dep.exceptions(ent, fs)
+	}
+	return e.exceptionProvider.Exception(ent, fs), nil
+}
+
+// build builds the event and runs the before capture hooks. Sensitive data is scrubbed
+// after the hooks, so data they add is scrubbed too.
+// Everything reading the fields happens here, on the logging goroutine, so fields can't be
+// modified while they are read. It returns nil if a hook drops the event.
+func (e *events) build(
+	hub *sentry.Hub,
+	ent zapcore.Entry,
+	fs []zapcore.Field,
+	extra map[string]interface{},
+) *draft {
+	event, traces := e.new(ent, fs, extra)
+	for _, fn := range e.beforeCapture {
+		if event = fn(event, ent, fs); event == nil {
+			return nil
+		}
+	}
+	if e.scrubber != nil {
+		e.scrubber.scrubEvent(event, e.userKeys)
+	}
+	return &draft{hub: hub, event: event, stacktraces: traces}
+}
+
+// draft is a built event, which can be captured on another goroutine.
+type draft struct {
+	hub   *sentry.Hub
+	event *sentry.Event
+	// stacktraces are the stack traces of the event's exceptions, symbolized on capture if
+	// they aren't yet.
+	stacktraces *stacktraces
+}
+
+// capture symbolizes the stack traces and captures the event with the hub.
+func (d *draft) capture() {
+	d.stacktraces.symbolize()
+	_ = d.hub.CaptureEvent(d.event)
+}
+
+// clone returns a copy of the draft which can be captured separately.
+// Capturing an event modifies it's maps, so they are copied.
+func (d *draft) clone() *draft {
+	event := *d.event
+	event.Tags = make(map[string]string, len(d.event.Tags))
+	for k, v := range d.event.Tags {
+		event.Tags[k] = v
+	}
+	event.Extra = copyExtra(d.event.Extra)
+	event.Contexts = make(map[string]interface{}, len(d.event.Contexts))
+	for k, v := range d.event.Contexts {
+		event.Contexts[k] = v
+	}
+	event.Breadcrumbs = append([]*sentry.Breadcrumb(nil), d.event.Breadcrumbs...)
+	return &draft{hub: d.hub, event: &event, stacktraces: d.stacktraces}
+}
+
+// copyExtra returns a copy of extra.
+func copyExtra(extra map[string]interface{}) map[string]interface{} {
+	m := make(map[string]interface{}, len(extra))
+	for k, v := range extra {
+		m[k] = v
+	}
+	return m
+}
+
+// withExtra returns a copy of extra with the key set.
+// Extra has to be copied since it's shared with the breadcrumbs.
+func withExtra(extra map[string]interface{}, key string, value interface{}) map[string]interface{} {
+	m := copyExtra(extra)
+	m[key] = value
+	return m
+}
+
+// normalize returns the entry with a normalized message if the normalizer is set.
+// The original message is kept in a copy of extra if normalization changed it.
+func (e *events) normalize(
+	ent zapcore.Entry,
+	extra map[string]interface{},
+) (zapcore.Entry, map[string]interface{}) {
+	if e.normalizer == nil {
+		return ent, extra
+	}
+	normalized := e.normalizer.Normalize(ent.Message)
+	if normalized == ent.Message {
+		return ent, extra
+	}
+
+	extra = withExtra(extra, originalMessageKey, ent.Message)
+	ent.Message = normalized
+	return ent, extra
 }
 
+// fingerprint returns the fingerprint of the last Fingerprint field, or the one provided by
+// the fingerprinter if there are no Fingerprint fields.
+// It returns nil if there is no fingerprinter.
+func (e *events) fingerprint(
+	ent zapcore.Entry,
+	fs []zapcore.Field,
+	exceptions []sentry.Exception,
+) []string {
+	if fingerprint := findFingerprint(fs); fingerprint != nil {
+		return fingerprint
+	}
+	if e.fingerprinter == nil {
+		return nil
+	}
+	return e.fingerprinter.Fingerprint(ent, fs, exceptions)
+}
+
+// tagsFromFields returns the tags of the fields with registered keys.
+// Objects implementing Tagger provide their own tags, other fields are converted to their
+// canonical string forms, see tagValue.
 func (e *events) tagsFromFields(fs []zapcore.Field) map[string]string {
 	tags := make(map[string]string)
 	for _, f := range fs {
 		if _, ok := e.registeredTagKeys[f.Key]; !ok {
 			continue
 		}
-		if f.Type == zapcore.ObjectMarshalerType {
-			tags = e.tryAddObjectTag(f, tags)
-			continue
-		}
-		if f.Type == zapcore.StringType {
-			tags[f.Key] = f.String
-			continue
+		if isObjectField(f) {
+			if _, ok := f.Interface.(Tagger); ok {
+				tags = e.tryAddObjectTag(f, tags)
+				continue
+			}
 		}
-		if f.Type == zapcore.ReflectType {
-			tags = e.tryAddObjectTag(f, tags)
-			continue
+		if v, ok := tagValue(f); ok {
+			tags[f.Key] = v
 		}
 	}
 	return tags
 }
 
+// isObjectField returns true if the field holds an object, which could be a Tagger.
+func isObjectField(f zapcore.Field) bool {
+	return f.Type == zapcore.ObjectMarshalerType || f.Type == zapcore.ReflectType
+}
+
 func (e *events) tryAddObjectTag(field zapcore.Field, tags map[string]string) map[string]string {
 	if field.Interface == nil {
 		return tags
@@ -1,19 +1,31 @@
 package zapsentry
 
 import (
+	"fmt"
+	"reflect"
+	"runtime"
 	"strings"
+	"sync"
 
 	"github.com/getsentry/sentry-go"
+	"go.uber.org/multierr"
 	"go.uber.org/zap/zapcore"
 )
 
-// ExceptionProvider provides sentry exceptions from zapcore's entries.
+// maxErrorDepth is the maximum number of errors unwrapped from an error chain.
+const maxErrorDepth = 10
+
+// zapSentryCallersKey is the key of the field holding the program counters of the
+// exceptions' stack trace, see callersField.
+const zapSentryCallersKey = "_zapsentry_callers_"
+
+// ExceptionProvider provides sentry exceptions from zapcore's entries and fields.
 type ExceptionProvider interface {
-	// Exception accepts a zapcore.Entry and provides a sentry exception.
+	// Exception accepts a zapcore.Entry with it's fields and provides a sentry exception.
 	// Sentry defines it's exception as an Exception array.
 	// This array should contain one exception if an exception exists
 	// It will return an empty array if no exception is created.
-	Exception(ent zapcore.Entry) []sentry.Exception
+	Exception(ent zapcore.Entry, fs []zapcore.Field) []sentry.Exception
 }
 
 var _ ExceptionProvider = (*NopExceptionProvider)(nil)
@@ -26,40 +38,287 @@ type NopExceptionProvider struct{}
 // nopExceptionProvider is a global nopExceptionProvider
 var nopExceptionProvider = &NopExceptionProvider{}
 
-// Exception accepts a zapcore.Entry and provides a sentry exception.
+// Exception accepts a zapcore.Entry with it's fields and provides a sentry exception.
 // Sentry defines it's exception as an Exception array.
 // This array should contain one exception if an exception exists
 // It will return an empty array if no exception is created.
-func (nep *NopExceptionProvider) Exception(_ zapcore.Entry) []sentry.Exception { return nil }
+func (nep *NopExceptionProvider) Exception(_ zapcore.Entry, _ []zapcore.Field) []sentry.Exception {
+	return nil
+}
 
 var _ ExceptionProvider = (*DefaultExceptionProvider)(nil)
 
 type DefaultExceptionProvider struct {
 	frameFilter StacktraceFrameFilter
+	extractors  StacktraceExtractors
+
+	// useEntryStack is true if the stack trace zap already took should be parsed
+	// instead of taking a new one.
+	useEntryStack bool
 }
 
 // NewExceptionProvider returns anew DefaultExceptionProvider with the passed StacktraceFrameFilter
 // as it's frame filter.
-func NewExceptionProvider(ff StacktraceFrameFilter) *DefaultExceptionProvider {
-	return &DefaultExceptionProvider{frameFilter: ff}
+// The passed StacktraceExtractors are used to find the stack traces carried by errors,
+// they are tried before the DefaultStacktraceExtractors.
+func NewExceptionProvider(ff StacktraceFrameFilter, extractors ...StacktraceExtractor) *DefaultExceptionProvider {
+	all := make(StacktraceExtractors, 0, len(extractors)+len(DefaultStacktraceExtractors))
+	all = append(all, extractors...)
+	all = append(all, DefaultStacktraceExtractors...)
+	return &DefaultExceptionProvider{
+		frameFilter: ff,
+		extractors:  all,
+	}
 }
 
-// Exception accepts a zapcore.Entry and provides a sentry exception.
+// Exception accepts a zapcore.Entry with it's fields and provides a sentry exception.
 // Sentry defines it's exception as an Exception array.
 // This array should contain one exception if an exception exists
 // It will return an empty array if no exception is created.
-func (dep *DefaultExceptionProvider) Exception(ent zapcore.Entry) []sentry.Exception {
-	trace := sentry.NewStacktrace()
-	if trace == nil {
-		return nopExceptionProvider.Exception(ent)
+//
+// If there are zap.Error or zap.Errors fields, every error in their chains becomes an
+// exception, with the exception type being the error's concrete Go type and the exception
+// value being the error message. Errors carrying their own stack trace get it attached.
+// Errors combined with go.uber.org/multierr and zap.Errors fields produce one group of
+// exceptions per contained error.
+// Otherwise the entry message and caller are used.
+func (dep *DefaultExceptionProvider) Exception(ent zapcore.Entry, fs []zapcore.Field) []sentry.Exception {
+	exceptions, traces := dep.exceptions(ent, fs)
+	traces.symbolize()
+	return exceptions
+}
+
+// exceptions returns the exceptions of the entry, with stack traces which aren't symbolized
+// until the returned stacktraces are.
+func (dep *DefaultExceptionProvider) exceptions(
+	ent zapcore.Entry,
+	fs []zapcore.Field,
+) ([]sentry.Exception, *stacktraces) {
+	traces := newStacktraces(dep.frameFilter)
+	if errs := errorsFromFields(fs); len(errs) > 0 {
+		// The call site stack trace is shared by all the errors which don't carry one.
+		var siteTrace *sentry.Stacktrace
+		stacktrace := func() *sentry.Stacktrace {
+			if siteTrace == nil {
+				siteTrace = traces.add(dep.stacktrace(ent, fs))
+			}
+			return siteTrace
+		}
+
+		exceptions := make([]sentry.Exception, 0, len(errs))
+		for _, err := range errs {
+			exceptions = append(exceptions, dep.exceptionsFromError(err, traces, stacktrace)...)
+		}
+		return exceptions, traces
 	}
 
-	trace.Frames = dep.frameFilter.FilterFrames(trace.Frames)
 	return []sentry.Exception{{
 		Type:       ent.Message,
 		Value:      ent.Caller.TrimmedPath(),
-		Stacktrace: trace,
-	}}
+		Stacktrace: traces.add(dep.stacktrace(ent, fs)),
+	}}, traces
+}
+
+// stacktrace returns the source of the stack trace of the logging call site.
+// When using the entry stack, the stack trace zap took for the entry is used if there is
+// one. Program counters of a callers field are used next, otherwise the program counters
+// of the calling goroutine are taken.
+func (dep *DefaultExceptionProvider) stacktrace(ent zapcore.Entry, fs []zapcore.Field) stackSource {
+	if dep.useEntryStack && ent.Stack != "" {
+		if frames := parseEntryStack(ent.Stack); len(frames) > 0 {
+			return stackSource{frames: frames}
+		}
+	}
+	for _, f := range fs {
+		if pcs := getCallers(f); len(pcs) > 0 {
+			return stackSource{pcs: pcs}
+		}
+	}
+	pcs := make([]uintptr, 100)
+	return stackSource{pcs: pcs[:runtime.Callers(1, pcs)]}
+}
+
+// getCallers returns the program counters of a callers field.
+// It returns nil if the field isn't a callers field.
+func getCallers(field zapcore.Field) []uintptr {
+	if field.Type == zapcore.SkipType && field.Key == zapSentryCallersKey {
+		if pcs, ok := field.Interface.([]uintptr); ok {
+			return pcs
+		}
+	}
+	return nil
+}
+
+// stackSource is what a stack trace is symbolized from, either program counters or frames
+// which are already known.
+type stackSource struct {
+	pcs    []uintptr
+	frames []sentry.Frame
+}
+
+// stacktraces holds the sources of the stack traces of an event's exceptions.
+// Symbolizing program counters is the most expensive part of building an event, so it's
+// deferred until the event is captured, which may happen on another goroutine.
+type stacktraces struct {
+	frameFilter StacktraceFrameFilter
+	sources     map[*sentry.Stacktrace]stackSource
+	once        sync.Once
+}
+
+func newStacktraces(ff StacktraceFrameFilter) *stacktraces {
+	return &stacktraces{
+		frameFilter: ff,
+		sources:     make(map[*sentry.Stacktrace]stackSource),
+	}
+}
+
+// add returns an empty stack trace, which frames are set from the source when symbolized.
+func (st *stacktraces) add(source stackSource) *sentry.Stacktrace {
+	trace := &sentry.Stacktrace{}
+	st.sources[trace] = source
+	return trace
+}
+
+// symbolize sets the filtered frames of all the stack traces.
+// It's safe to call concurrently and more than once, only the first call symbolizes.
+func (st *stacktraces) symbolize() {
+	if st == nil {
+		return
+	}
+	st.once.Do(func() {
+		for trace, source := range st.sources {
+			frames := source.frames
+			if frames == nil {
+				frames = framesFromPCs(source.pcs)
+			}
+			trace.Frames = st.frameFilter.FilterFrames(frames)
+		}
+	})
+}
+
+// exceptionsFromError returns an exception for every error in the err chain.
+// Exceptions are ordered the way sentry expects them, from the root cause to the outermost
+// error. Every error carrying a stack trace gets it attached, if none of them carry one
+// the stack trace returned by siteTrace is attached to the outermost error.
+func (dep *DefaultExceptionProvider) exceptionsFromError(
+	err error,
+	traces *stacktraces,
+	siteTrace func() *sentry.Stacktrace,
+) []sentry.Exception {
+	exceptions := make([]sentry.Exception, 0, 1)
+	hasStacktrace := false
+	for i := 0; i < maxErrorDepth && !isNilError(err); i++ {
+		exception := sentry.Exception{
+			Type:  errorType(err),
+			Value: errorMessage(err),
+		}
+		if pcs := dep.extractors.Extract(err); len(pcs) > 0 {
+			exception.Stacktrace = traces.add(stackSource{pcs: pcs})
+			hasStacktrace = true
+		}
+		exceptions = append(exceptions, exception)
+		err = unwrapError(err)
+	}
+
+	if len(exceptions) == 0 {
+		return nil
+	}
+
+	// Reverse the exceptions so the outermost error is the last one.
+	for i, j := 0, len(exceptions)-1; i < j; i, j = i+1, j-1 {
+		exceptions[i], exceptions[j] = exceptions[j], exceptions[i]
+	}
+	if !hasStacktrace {
+		exceptions[len(exceptions)-1].Stacktrace = siteTrace()
+	}
+	return exceptions
+}
+
+// unwrapError returns the next error in the err chain.
+// It supports both the standard library Unwrap and the github.com/pkg/errors Cause methods.
+// It returns nil if err doesn't wrap another error.
+func unwrapError(err error) error {
+	switch e := err.(type) {
+	case interface{ Unwrap() error }:
+		return e.Unwrap()
+	case interface{ Cause() error }:
+		return e.Cause()
+	}
+	return nil
+}
+
+// errorsFromFields returns the errors of all zap.Error and zap.Errors fields.
+// Errors combined with go.uber.org/multierr are split into the errors they contain.
+// It returns nil if there are no error fields.
+func errorsFromFields(fs []zapcore.Field) []error {
+	var errs []error
+	for _, f := range fs {
+		switch f.Type {
+		case zapcore.ErrorType:
+			if err, ok := f.Interface.(error); ok && !isNilError(err) {
+				errs = append(errs, multierr.Errors(err)...)
+			}
+		case zapcore.ArrayMarshalerType:
+			for _, err := range errorSlice(f.Interface) {
+				errs = append(errs, multierr.Errors(err)...)
+			}
+		}
+	}
+	return errs
+}
+
+// errorReflectType is the reflect.Type of the error interface.
+var errorReflectType = reflect.TypeOf((*error)(nil)).Elem()
+
+// errorSlice returns the errors of the passed slice of errors, like the array zap.Errors
+// builds. It returns nil if v isn't a slice of errors.
+func errorSlice(v interface{}) []error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice || rv.Type().Elem() != errorReflectType {
+		return nil
+	}
+
+	errs := make([]error, 0, rv.Len())
+	for i := 0; i < rv.Len(); i++ {
+		if err, ok := rv.Index(i).Interface().(error); ok && !isNilError(err) {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// isNilError returns true if err is nil or a typed nil, like a nil *MyError.
+// Typed nils are skipped, calling their methods would likely panic.
+func isNilError(err error) bool {
+	if err == nil {
+		return true
+	}
+	rv := reflect.ValueOf(err)
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan, reflect.Interface:
+		return rv.IsNil()
+	}
+	return false
+}
+
+// errorMessage returns the error's message.
+// Like zap, it recovers Error panics, using "<nil>" for nil pointer receivers.
+func errorMessage(err error) (msg string) {
+	defer func() {
+		if p := recover(); p != nil {
+			if rv := reflect.ValueOf(err); rv.Kind() == reflect.Ptr && rv.IsNil() {
+				msg = "<nil>"
+				return
+			}
+			msg = fmt.Sprintf("PANIC=%v", p)
+		}
+	}()
+	return err.Error()
+}
+
+// errorType returns the name of the error's concrete Go type, e.g. *fs.PathError.
+func errorType(err error) string {
+	return fmt.Sprintf("%T", err)
 }
 
 // StacktraceFrameFilter filters stacktrace frames.
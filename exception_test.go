@@ -0,0 +1,190 @@
+package zapsentry
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"testing"
+
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestDefaultExceptionProviderUsesErrorField(t *testing.T) {
+	ep := NewExceptionProvider(&DefaultStacktraceFrameFilter{})
+	err := &os.PathError{Op: "open", Path: "/nope", Err: errors.New("no such file")}
+
+	exceptions := ep.Exception(zapcore.Entry{Message: "failed to open"}, []zapcore.Field{zap.Error(err)})
+	if len(exceptions) != 2 {
+		t.Fatalf("expected 2 exceptions, got %d", len(exceptions))
+	}
+	if exceptions[1].Type != "*fs.PathError" {
+		t.Errorf("expected type *fs.PathError, got %q", exceptions[1].Type)
+	}
+	if exceptions[1].Value != err.Error() {
+		t.Errorf("expected value %q, got %q", err.Error(), exceptions[1].Value)
+	}
+}
+
+func TestDefaultExceptionProviderWithoutErrorField(t *testing.T) {
+	ep := NewExceptionProvider(&DefaultStacktraceFrameFilter{})
+
+	exceptions := ep.Exception(zapcore.Entry{Message: "something went wrong"}, nil)
+	if len(exceptions) != 1 {
+		t.Fatalf("expected 1 exception, got %d", len(exceptions))
+	}
+	if exceptions[0].Type != "something went wrong" {
+		t.Errorf("expected type to be the message, got %q", exceptions[0].Type)
+	}
+}
+
+type causer struct {
+	msg   string
+	cause error
+}
+
+func (c *causer) Error() string { return c.msg + ": " + c.cause.Error() }
+
+func (c *causer) Cause() error { return c.cause }
+
+func TestDefaultExceptionProviderUnwrapsErrorChain(t *testing.T) {
+	ep := NewExceptionProvider(&DefaultStacktraceFrameFilter{})
+	root := errors.New("root cause")
+	err := fmt.Errorf("outer: %w", &causer{msg: "middle", cause: root})
+
+	exceptions := ep.Exception(zapcore.Entry{}, []zapcore.Field{zap.Error(err)})
+	if len(exceptions) != 3 {
+		t.Fatalf("expected 3 exceptions, got %d", len(exceptions))
+	}
+	if exceptions[0].Value != root.Error() {
+		t.Errorf("expected the root cause first, got %q", exceptions[0].Value)
+	}
+	if exceptions[1].Type != "*zapsentry.causer" {
+		t.Errorf("expected the causer second, got %q", exceptions[1].Type)
+	}
+	if exceptions[2].Value != err.Error() {
+		t.Errorf("expected the outermost error last, got %q", exceptions[2].Value)
+	}
+	if exceptions[2].Stacktrace == nil || exceptions[0].Stacktrace != nil {
+		t.Errorf("expected only the outermost error to carry the stack trace")
+	}
+}
+
+type pkgFrame uintptr
+
+type stackError struct {
+	pcs []uintptr
+}
+
+func newStackError() *stackError {
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(1, pcs)
+	return &stackError{pcs: pcs[:n]}
+}
+
+func (e *stackError) Error() string { return "stack error" }
+
+func (e *stackError) StackTrace() []pkgFrame {
+	frames := make([]pkgFrame, len(e.pcs))
+	for i, pc := range e.pcs {
+		frames[i] = pkgFrame(pc)
+	}
+	return frames
+}
+
+func TestDefaultExceptionProviderUsesErrorStacktrace(t *testing.T) {
+	ep := NewExceptionProvider(&DefaultStacktraceFrameFilter{})
+	err := fmt.Errorf("wrapped: %w", newStackError())
+
+	exceptions := ep.Exception(zapcore.Entry{}, []zapcore.Field{zap.Error(err)})
+	if len(exceptions) != 2 {
+		t.Fatalf("expected 2 exceptions, got %d", len(exceptions))
+	}
+	if exceptions[1].Stacktrace != nil {
+		t.Errorf("expected no log site stack trace when the error carries one")
+	}
+	trace := exceptions[0].Stacktrace
+	if trace == nil || len(trace.Frames) == 0 {
+		t.Fatalf("expected the stack trace carried by the error")
+	}
+	if last := trace.Frames[len(trace.Frames)-1]; last.Function != "newStackError" {
+		t.Errorf("expected the newest frame to be newStackError, got %q", last.Function)
+	}
+}
+
+func TestDefaultExceptionProviderSplitsMultiErrors(t *testing.T) {
+	ep := NewExceptionProvider(&DefaultStacktraceFrameFilter{})
+	first, second, third := errors.New("first"), errors.New("second"), errors.New("third")
+
+	exceptions := ep.Exception(zapcore.Entry{}, []zapcore.Field{
+		zap.Error(multierr.Combine(first, second)),
+		zap.Errors("errors", []error{third}),
+	})
+	if len(exceptions) != 3 {
+		t.Fatalf("expected 3 exceptions, got %d", len(exceptions))
+	}
+	for i, err := range []error{first, second, third} {
+		if exceptions[i].Value != err.Error() {
+			t.Errorf("expected exception %d to be %q, got %q", i, err.Error(), exceptions[i].Value)
+		}
+		if exceptions[i].Stacktrace == nil {
+			t.Errorf("expected exception %d to carry the call site stack trace", i)
+		}
+	}
+}
+
+func TestDefaultExceptionProviderUsesEntryStack(t *testing.T) {
+	ep := NewExceptionProvider(&DefaultStacktraceFrameFilter{})
+	ep.useEntryStack = true
+	ent := zapcore.Entry{
+		Message: "something went wrong",
+		Stack: "github.com/acme/app/handler.Serve\n\t/src/app/handler/handler.go:42\n" +
+			"main.main\n\t/src/app/main.go:10",
+	}
+
+	exceptions := ep.Exception(ent, nil)
+	if len(exceptions) != 1 {
+		t.Fatalf("expected 1 exception, got %d", len(exceptions))
+	}
+	frames := exceptions[0].Stacktrace.Frames
+	if len(frames) != 2 {
+		t.Fatalf("expected 2 frames, got %d", len(frames))
+	}
+	if frames[0].Function != "main" || frames[0].Lineno != 10 {
+		t.Errorf("expected main.main to be the oldest frame, got %+v", frames[0])
+	}
+	if frames[1].Module != "github.com/acme/app/handler" || frames[1].Function != "Serve" ||
+		frames[1].AbsPath != "/src/app/handler/handler.go" || frames[1].Lineno != 42 {
+		t.Errorf("expected handler.Serve to be the newest frame, got %+v", frames[1])
+	}
+}
+
+type nilError struct{ msg string }
+
+func (e *nilError) Error() string { return e.msg }
+
+type panicError struct{}
+
+func (panicError) Error() string { panic("boom") }
+
+func TestDefaultExceptionProviderSkipsTypedNilErrors(t *testing.T) {
+	ep := NewExceptionProvider(&DefaultStacktraceFrameFilter{})
+
+	exceptions := ep.Exception(zapcore.Entry{Message: "failed"}, []zapcore.Field{
+		zap.Error((*nilError)(nil)),
+		zap.Errors("errors", []error{(*nilError)(nil), fmt.Errorf("wrapped: %w", (*nilError)(nil))}),
+	})
+	if len(exceptions) != 1 {
+		t.Fatalf("expected 1 exception, got %d", len(exceptions))
+	}
+	if exceptions[0].Value != "wrapped: <nil>" {
+		t.Errorf("expected only the wrapping error, got %q", exceptions[0].Value)
+	}
+
+	exceptions = ep.Exception(zapcore.Entry{}, []zapcore.Field{zap.Error(panicError{})})
+	if len(exceptions) != 1 || exceptions[0].Value != "PANIC=boom" {
+		t.Errorf("expected the Error panic to be recovered, got %+v", exceptions)
+	}
+}
@@ -0,0 +1,9 @@
+package zapsentry
+
+// WithClock replaces the clock of deduplication windows and rate limits.
+func WithClock(c clock) Option {
+	return func(core *core) error {
+		core.clock = c
+		return nil
+	}
+}
@@ -0,0 +1,100 @@
+package zapsentry
+
+import (
+	"net/http"
+
+	"github.com/getsentry/sentry-go"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// These constants define the keys of fields setting sentry event attributes.
+const (
+	zapSentryTagKey         = "_zapsentry_tag_"
+	zapSentryEventCtxKey    = "_zapsentry_event_context_"
+	zapSentryTransactionKey = "_zapsentry_transaction_"
+	zapSentryLevelKey       = "_zapsentry_level_"
+	zapSentryRequestKey     = "_zapsentry_request_"
+)
+
+// tag is the value of a Tag field.
+type tag struct {
+	key   string
+	value string
+}
+
+// eventContext is the value of an EventContext field.
+type eventContext struct {
+	key   string
+	value interface{}
+}
+
+// skipField returns a field which is skipped by encoders, holding the value.
+func skipField(key string, value interface{}) zapcore.Field {
+	f := zap.Skip()
+	f.Interface = value
+	f.Key = key
+	return f
+}
+
+// Tag sets a tag on the logged event. It takes precedence over tags set with WithTags and
+// tags converted from fields.
+func Tag(key, value string) zapcore.Field {
+	return skipField(zapSentryTagKey, tag{key: key, value: value})
+}
+
+// EventContext sets a context on the logged event, e.g. the state of a component.
+//
+// https://develop.sentry.dev/sdk/event-payloads/contexts/
+func EventContext(key string, value interface{}) zapcore.Field {
+	return skipField(zapSentryEventCtxKey, eventContext{key: key, value: value})
+}
+
+// Transaction sets the transaction of the logged event.
+func Transaction(name string) zapcore.Field {
+	return skipField(zapSentryTransactionKey, name)
+}
+
+// EventLevel overrides the level of the logged event, which is otherwise mapped from the
+// entry level.
+func EventLevel(level sentry.Level) zapcore.Field {
+	return skipField(zapSentryLevelKey, level)
+}
+
+// Request sets the request of the logged event.
+// The request is converted to a sentry.Request when the field is created.
+func Request(r *http.Request) zapcore.Field {
+	return skipField(zapSentryRequestKey, sentry.NewRequest(r))
+}
+
+// applyFields sets the event attributes from the fields setting them.
+// If a field is passed more than once, the last one wins.
+func applyFields(event *sentry.Event, fs []zapcore.Field) {
+	for _, f := range fs {
+		if f.Type != zapcore.SkipType {
+			continue
+		}
+		switch v := f.Interface.(type) {
+		case tag:
+			if f.Key == zapSentryTagKey {
+				event.Tags[v.key] = v.value
+			}
+		case eventContext:
+			if f.Key == zapSentryEventCtxKey {
+				event.Contexts[v.key] = v.value
+			}
+		case string:
+			if f.Key == zapSentryTransactionKey {
+				event.Transaction = v
+			}
+		case sentry.Level:
+			if f.Key == zapSentryLevelKey {
+				event.Level = v
+			}
+		case *sentry.Request:
+			if f.Key == zapSentryRequestKey {
+				event.Request = v
+			}
+		}
+	}
+}
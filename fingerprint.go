@@ -0,0 +1,99 @@
+package zapsentry
+
+import (
+	"github.com/getsentry/sentry-go"
+	"go.uber.org/zap/zapcore"
+)
+
+const zapSentryFingerprintKey = "_zapsentry_fingerprint_"
+
+// Fingerprinter provides sentry fingerprints, which sentry uses to group events into issues.
+//
+// https://docs.sentry.io/product/sentry-basics/grouping-and-fingerprints/
+type Fingerprinter interface {
+	// Fingerprint accepts a zapcore.Entry with it's fields and the exceptions provided for
+	// it and returns the event's fingerprint.
+	// It will return nil if sentry's default grouping should be used.
+	Fingerprint(ent zapcore.Entry, fs []zapcore.Field, exceptions []sentry.Exception) []string
+}
+
+// FingerprinterFunc is an adapter to allow the use of ordinary functions as Fingerprinters.
+type FingerprinterFunc func(ent zapcore.Entry, fs []zapcore.Field, exceptions []sentry.Exception) []string
+
+// Fingerprint calls f(ent, fs, exceptions).
+func (f FingerprinterFunc) Fingerprint(
+	ent zapcore.Entry,
+	fs []zapcore.Field,
+	exceptions []sentry.Exception,
+) []string {
+	return f(ent, fs, exceptions)
+}
+
+var _ Fingerprinter = (*MessageFingerprinter)(nil)
+
+// MessageFingerprinter is a Fingerprinter which groups events by the logger name and the
+// message template, the message passed to the logger.
+type MessageFingerprinter struct{}
+
+// Fingerprint returns the logger name and the entry message.
+func (mf *MessageFingerprinter) Fingerprint(ent zapcore.Entry, _ []zapcore.Field, _ []sentry.Exception) []string {
+	return []string{ent.LoggerName, ent.Message}
+}
+
+var _ Fingerprinter = (*ErrorTypeFingerprinter)(nil)
+
+// ErrorTypeFingerprinter is a Fingerprinter which groups events by the type of the
+// outermost exception and the caller.
+// Events without exceptions are grouped by the message instead of the exception type.
+type ErrorTypeFingerprinter struct{}
+
+// Fingerprint returns the outermost exception type and the caller.
+func (ef *ErrorTypeFingerprinter) Fingerprint(
+	ent zapcore.Entry,
+	_ []zapcore.Field,
+	exceptions []sentry.Exception,
+) []string {
+	kind := ent.Message
+	if len(exceptions) > 0 {
+		kind = exceptions[len(exceptions)-1].Type
+	}
+	return []string{kind, callerName(ent.Caller)}
+}
+
+// callerName returns the caller function name, or the caller trimmed path if the function
+// isn't known. The function is preferred since it doesn't change when lines are moved.
+func callerName(caller zapcore.EntryCaller) string {
+	if caller.Function != "" {
+		return caller.Function
+	}
+	return caller.TrimmedPath()
+}
+
+// Fingerprint sets the fingerprint of the logged event, overriding the configured
+// Fingerprinter.
+func Fingerprint(fingerprint ...string) zapcore.Field {
+	return skipField(zapSentryFingerprintKey, fingerprint)
+}
+
+// getFingerprint returns the fingerprint of a Fingerprint field.
+// It returns nil if the field isn't a Fingerprint field.
+func getFingerprint(field zapcore.Field) []string {
+	if field.Type == zapcore.SkipType && field.Key == zapSentryFingerprintKey {
+		if fingerprint, ok := field.Interface.([]string); ok {
+			return fingerprint
+		}
+	}
+	return nil
+}
+
+// findFingerprint returns the fingerprint of the last Fingerprint field, so fields passed
+// to the log call take precedence over the ones added with With.
+// It returns nil if there are no Fingerprint fields.
+func findFingerprint(fs []zapcore.Field) []string {
+	for i := len(fs) - 1; i >= 0; i-- {
+		if fingerprint := getFingerprint(fs[i]); fingerprint != nil {
+			return fingerprint
+		}
+	}
+	return nil
+}
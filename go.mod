@@ -4,10 +4,6 @@ go 1.16
 
 require (
 	github.com/getsentry/sentry-go v0.11.0
+	go.uber.org/multierr v1.6.0
 	go.uber.org/zap v1.19.1
 )
-
-require (
-	go.uber.org/atomic v1.7.0 // indirect
-	go.uber.org/multierr v1.6.0 // indirect
-)
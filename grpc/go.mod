@@ -0,0 +1,10 @@
+module github.com/l2cup/zapsentry/grpc
+
+go 1.16
+
+require (
+	github.com/getsentry/sentry-go v0.11.0
+	github.com/l2cup/zapsentry v0.0.0-20261016210159-165128bbabd6
+	go.uber.org/zap v1.19.1
+	google.golang.org/grpc v1.43.0
+)
@@ -0,0 +1,202 @@
+// Package zapsentrygrpc provides gRPC interceptors which give every call it's own sentry
+// hub and a zap logger bound to it.
+package zapsentrygrpc
+
+import (
+	"context"
+	"strings"
+
+	"github.com/getsentry/sentry-go"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/l2cup/zapsentry"
+)
+
+// These constants define the tags set on every call scope.
+const (
+	// methodTag is the full gRPC method name, e.g. /grpc.health.v1.Health/Check
+	methodTag = "grpc.method"
+	// serviceTag is the gRPC service name, e.g. grpc.health.v1.Health
+	serviceTag = "grpc.service"
+	// codeKey is the field key of the status code of failed calls
+	codeKey = "grpc.code"
+)
+
+// Options configure the interceptors.
+type Options struct {
+	// Repanic configures whether server interceptors panic again after recovering and
+	// reporting a panic. When false, the call fails with codes.Internal instead.
+	Repanic bool
+
+	// CodeToLevel maps status codes of failed calls to the level they are logged at.
+	// Defaults to DefaultCodeToLevel.
+	CodeToLevel func(code codes.Code) zapcore.Level
+}
+
+// DefaultCodeToLevel logs failures caused by the client at warn level, and the ones caused
+// by the server at error level.
+func DefaultCodeToLevel(code codes.Code) zapcore.Level {
+	switch code {
+	case codes.Canceled, codes.InvalidArgument, codes.NotFound, codes.AlreadyExists,
+		codes.PermissionDenied, codes.Unauthenticated, codes.ResourceExhausted,
+		codes.FailedPrecondition, codes.Aborted, codes.OutOfRange:
+		return zapcore.WarnLevel
+	default:
+		return zapcore.ErrorLevel
+	}
+}
+
+// interceptor holds the state shared by all interceptors.
+type interceptor struct {
+	logger      *zap.Logger
+	repanic     bool
+	codeToLevel func(code codes.Code) zapcore.Level
+}
+
+func newInterceptor(logger *zap.Logger, opts Options) *interceptor {
+	codeToLevel := opts.CodeToLevel
+	if codeToLevel == nil {
+		codeToLevel = DefaultCodeToLevel
+	}
+	return &interceptor{
+		logger:      logger,
+		repanic:     opts.Repanic,
+		codeToLevel: codeToLevel,
+	}
+}
+
+// UnaryServerInterceptor returns a server interceptor for unary calls.
+// The logger should have a zapsentry core attached, see zapsentry.AttachCoreToLogger.
+func UnaryServerInterceptor(logger *zap.Logger, opts Options) grpc.UnaryServerInterceptor {
+	i := newInterceptor(logger, opts)
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (resp interface{}, err error) {
+		ctx, logger := i.scope(ctx, info.FullMethod)
+		defer i.recover(logger, &err)
+
+		resp, err = handler(ctx, req)
+		i.logError(logger, err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor returns a server interceptor for streaming calls.
+// The logger should have a zapsentry core attached, see zapsentry.AttachCoreToLogger.
+func StreamServerInterceptor(logger *zap.Logger, opts Options) grpc.StreamServerInterceptor {
+	i := newInterceptor(logger, opts)
+	return func(
+		srv interface{},
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) (err error) {
+		ctx, logger := i.scope(ss.Context(), info.FullMethod)
+		defer i.recover(logger, &err)
+
+		err = handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+		i.logError(logger, err)
+		return err
+	}
+}
+
+// UnaryClientInterceptor returns a client interceptor for unary calls.
+// The logger should have a zapsentry core attached, see zapsentry.AttachCoreToLogger.
+func UnaryClientInterceptor(logger *zap.Logger, opts Options) grpc.UnaryClientInterceptor {
+	i := newInterceptor(logger, opts)
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		callOpts ...grpc.CallOption,
+	) error {
+		ctx, logger := i.scope(ctx, method)
+		err := invoker(ctx, method, req, reply, cc, callOpts...)
+		i.logError(logger, err)
+		return err
+	}
+}
+
+// StreamClientInterceptor returns a client interceptor for streaming calls.
+// Only failures to create the stream are logged.
+// The logger should have a zapsentry core attached, see zapsentry.AttachCoreToLogger.
+func StreamClientInterceptor(logger *zap.Logger, opts Options) grpc.StreamClientInterceptor {
+	i := newInterceptor(logger, opts)
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		callOpts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		ctx, logger := i.scope(ctx, method)
+		cs, err := streamer(ctx, desc, cc, method, callOpts...)
+		i.logError(logger, err)
+		return cs, err
+	}
+}
+
+// scope clones the sentry hub for the call, sets the method as it's transaction and tags
+// and returns a context carrying the hub and a logger bound to it, see
+// zapsentry.LoggerFromContext.
+func (i *interceptor) scope(ctx context.Context, method string) (context.Context, *zap.Logger) {
+	ctx, logger := zapsentry.NewScopedContext(ctx, i.logger)
+	scope := sentry.GetHubFromContext(ctx).Scope()
+	scope.SetTransaction(method)
+	scope.SetTag(methodTag, method)
+	scope.SetTag(serviceTag, serviceName(method))
+	return ctx, logger
+}
+
+// logError logs the failed call with it's status code.
+func (i *interceptor) logError(logger *zap.Logger, err error) {
+	if err == nil {
+		return
+	}
+	code := status.Code(err)
+	if ce := logger.Check(i.codeToLevel(code), "grpc call failed"); ce != nil {
+		ce.Write(zap.Error(err), zap.String(codeKey, code.String()))
+	}
+}
+
+// recover recovers a panic and reports it as a fatal event.
+func (i *interceptor) recover(logger *zap.Logger, err *error) {
+	recovered := recover()
+	if recovered == nil {
+		return
+	}
+
+	zapsentry.LogPanic(logger, recovered)
+	if i.repanic {
+		panic(recovered)
+	}
+	*err = status.Errorf(codes.Internal, "panic: %v", recovered)
+}
+
+// serviceName returns the service part of the full method name.
+func serviceName(method string) string {
+	method = strings.TrimPrefix(method, "/")
+	if i := strings.LastIndexByte(method, '/'); i >= 0 {
+		return method[:i]
+	}
+	return method
+}
+
+// serverStream overrides the context of a grpc.ServerStream.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context returns the call scoped context.
+func (s *serverStream) Context() context.Context { return s.ctx }
@@ -0,0 +1,246 @@
+package zapsentrygrpc_test
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/l2cup/zapsentry"
+	zapsentrygrpc "github.com/l2cup/zapsentry/grpc"
+)
+
+type healthServer struct {
+	grpc_health_v1.UnimplementedHealthServer
+}
+
+func (s *healthServer) Check(
+	ctx context.Context,
+	req *grpc_health_v1.HealthCheckRequest,
+) (*grpc_health_v1.HealthCheckResponse, error) {
+	zapsentry.LoggerFromContext(ctx).Info("checking health", zap.String("service", req.Service))
+	switch req.Service {
+	case "panic":
+		panic("boom")
+	case "unavailable":
+		return nil, status.Error(codes.Unavailable, "database is down")
+	}
+	return &grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING}, nil
+}
+
+func (s *healthServer) Watch(
+	req *grpc_health_v1.HealthCheckRequest,
+	stream grpc_health_v1.Health_WatchServer,
+) error {
+	zapsentry.LoggerFromContext(stream.Context()).Info("watching health", zap.String("service", req.Service))
+	switch req.Service {
+	case "panic":
+		panic("boom")
+	case "unavailable":
+		return status.Error(codes.Unavailable, "database is down")
+	}
+	return stream.Send(&grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING})
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	logger, events := newRecordingLogger(t)
+	health := newHealthClient(t, []grpc.ServerOption{grpc.UnaryInterceptor(
+		zapsentrygrpc.UnaryServerInterceptor(logger, zapsentrygrpc.Options{}),
+	)})
+
+	_, err := health.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{Service: "unavailable"})
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("expected codes.Unavailable, got %v", err)
+	}
+	_, err = health.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{Service: "panic"})
+	if status.Code(err) != codes.Internal {
+		t.Errorf("expected codes.Internal, got %v", err)
+	}
+
+	checkServerEvents(t, events(), "/grpc.health.v1.Health/Check", "checking health")
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	logger, events := newRecordingLogger(t)
+	health := newHealthClient(t, []grpc.ServerOption{grpc.StreamInterceptor(
+		zapsentrygrpc.StreamServerInterceptor(logger, zapsentrygrpc.Options{}),
+	)})
+
+	for service, code := range map[string]codes.Code{"unavailable": codes.Unavailable, "panic": codes.Internal} {
+		stream, err := health.Watch(context.Background(), &grpc_health_v1.HealthCheckRequest{Service: service})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = stream.Recv(); status.Code(err) != code {
+			t.Errorf("expected %v, got %v", code, err)
+		}
+	}
+
+	checkServerEvents(t, events(), "/grpc.health.v1.Health/Watch", "watching health")
+}
+
+// checkServerEvents checks the events of a failed call and a panicking call, in any order.
+func checkServerEvents(t *testing.T, events []*sentry.Event, method, breadcrumb string) {
+	t.Helper()
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	var failed, panicked int
+	for _, event := range events {
+		if event.Transaction != method {
+			t.Errorf("expected the method as transaction, got %q", event.Transaction)
+		}
+		if event.Tags["grpc.service"] != "grpc.health.v1.Health" {
+			t.Errorf("expected the service tag, got %v", event.Tags)
+		}
+		if len(event.Breadcrumbs) == 0 || event.Breadcrumbs[0].Message != breadcrumb {
+			t.Errorf("expected the call breadcrumbs on the event, got %v", event.Breadcrumbs)
+		}
+		switch {
+		case event.Extra["grpc.code"] == codes.Unavailable.String():
+			failed++
+		case event.Level == sentry.LevelFatal:
+			panicked++
+		}
+	}
+	if failed != 1 || panicked != 1 {
+		t.Errorf("expected a failure event with the status code and a fatal panic event, got %+v", events)
+	}
+}
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	logger, events := newRecordingLogger(t)
+	health := newHealthClient(t, nil, grpc.WithUnaryInterceptor(
+		zapsentrygrpc.UnaryClientInterceptor(logger, zapsentrygrpc.Options{}),
+	))
+
+	_, err := health.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = health.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{Service: "unavailable"})
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("expected codes.Unavailable, got %v", err)
+	}
+
+	got := events()
+	if len(got) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(got))
+	}
+	if got[0].Transaction != "/grpc.health.v1.Health/Check" {
+		t.Errorf("expected the method as transaction, got %q", got[0].Transaction)
+	}
+	if got[0].Extra["grpc.code"] != codes.Unavailable.String() {
+		t.Errorf("expected the status code in extra, got %v", got[0].Extra)
+	}
+}
+
+func TestStreamClientInterceptor(t *testing.T) {
+	logger, events := newRecordingLogger(t)
+	health := newHealthClient(t, nil, grpc.WithStreamInterceptor(
+		zapsentrygrpc.StreamClientInterceptor(logger, zapsentrygrpc.Options{
+			CodeToLevel: func(codes.Code) zapcore.Level { return zapcore.ErrorLevel },
+		}),
+	))
+
+	stream, err := health.Watch(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = stream.Recv(); err != nil {
+		t.Fatal(err)
+	}
+	// Creating a stream with a canceled context fails.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = health.Watch(ctx, &grpc_health_v1.HealthCheckRequest{}); status.Code(err) != codes.Canceled {
+		t.Errorf("expected codes.Canceled, got %v", err)
+	}
+
+	got := events()
+	if len(got) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(got))
+	}
+	if got[0].Transaction != "/grpc.health.v1.Health/Watch" {
+		t.Errorf("expected the method as transaction, got %q", got[0].Transaction)
+	}
+	if got[0].Extra["grpc.code"] != codes.Canceled.String() {
+		t.Errorf("expected the status code in extra, got %v", got[0].Extra)
+	}
+}
+
+// newRecordingLogger returns a logger with a zapsentry core with breadcrumbs enabled and a
+// function returning the events sent to sentry.
+func newRecordingLogger(t *testing.T) (*zap.Logger, func() []*sentry.Event) {
+	t.Helper()
+	var mu sync.Mutex
+	var events []*sentry.Event
+	client, err := sentry.NewClient(sentry.ClientOptions{
+		Transport: &transport{MockSendEvent: func(event *sentry.Event) {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, event)
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	core, err := zapsentry.NewCore(
+		zapsentry.NewSentryClientFromClient(client),
+		zapsentry.WithBreadcrumbs(zapcore.InfoLevel),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return zap.New(core), func() []*sentry.Event {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]*sentry.Event(nil), events...)
+	}
+}
+
+func newHealthClient(
+	t *testing.T,
+	serverOpts []grpc.ServerOption,
+	dialOpts ...grpc.DialOption,
+) grpc_health_v1.HealthClient {
+	t.Helper()
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer(serverOpts...)
+	grpc_health_v1.RegisterHealthServer(server, &healthServer{})
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(server.Stop)
+
+	dialOpts = append(dialOpts,
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithInsecure(),
+	)
+	conn, err := grpc.Dial("bufnet", dialOpts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return grpc_health_v1.NewHealthClient(conn)
+}
+
+type transport struct {
+	MockSendEvent func(event *sentry.Event)
+}
+
+func (f *transport) Flush(_ time.Duration) bool { return true }
+
+func (f *transport) Configure(_ sentry.ClientOptions) {}
+
+func (f *transport) SendEvent(event *sentry.Event) { f.MockSendEvent(event) }
@@ -0,0 +1,74 @@
+// Package zapsentryhttp provides a net/http middleware which gives every request it's own
+// sentry hub and a zap logger bound to it.
+package zapsentryhttp
+
+import (
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/l2cup/zapsentry"
+)
+
+// Options configure the Handler.
+type Options struct {
+	// Repanic configures whether to panic again after recovering and reporting a panic.
+	// When false, the handler responds with 500 Internal Server Error instead.
+	Repanic bool
+}
+
+// Handler is a net/http middleware which clones the sentry hub for every request and stores
+// a logger bound to it and the request in the request context, see
+// zapsentry.LoggerFromContext. The request is a zapsentry.Request field of the logger, so
+// it's scrubbed with the rest of the event.
+// It recovers panics and reports them as fatal events.
+type Handler struct {
+	logger  *zap.Logger
+	repanic bool
+}
+
+// New returns a new Handler. The logger should have a zapsentry core attached, see
+// zapsentry.AttachCoreToLogger.
+func New(logger *zap.Logger, opts Options) *Handler {
+	return &Handler{
+		logger:  logger,
+		repanic: opts.Repanic,
+	}
+}
+
+// Handle wraps the passed http.Handler.
+func (h *Handler) Handle(handler http.Handler) http.Handler {
+	return h.handle(handler)
+}
+
+// HandleFunc wraps the passed http.HandlerFunc.
+func (h *Handler) HandleFunc(handler http.HandlerFunc) http.HandlerFunc {
+	return h.handle(handler)
+}
+
+func (h *Handler) handle(handler http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, logger := zapsentry.NewScopedContext(r.Context(), h.logger.With(zapsentry.Request(r)))
+
+		defer h.recover(logger, w)
+		handler.ServeHTTP(w, r.WithContext(ctx))
+	}
+}
+
+// recover recovers a panic and reports it as a fatal event.
+func (h *Handler) recover(logger *zap.Logger, w http.ResponseWriter) {
+	recovered := recover()
+	if recovered == nil {
+		return
+	}
+	// http.ErrAbortHandler is used to abort a handler on purpose.
+	if recovered == http.ErrAbortHandler {
+		panic(recovered)
+	}
+
+	zapsentry.LogPanic(logger, recovered)
+	if h.repanic {
+		panic(recovered)
+	}
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
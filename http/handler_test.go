@@ -0,0 +1,152 @@
+package zapsentryhttp_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/l2cup/zapsentry"
+	zapsentryhttp "github.com/l2cup/zapsentry/http"
+)
+
+func TestHandler(t *testing.T) {
+	var events []*sentry.Event
+	client, err := sentry.NewClient(sentry.ClientOptions{
+		Transport: &transport{MockSendEvent: func(event *sentry.Event) {
+			events = append(events, event)
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	core, err := zapsentry.NewCore(
+		zapsentry.NewSentryClientFromClient(client),
+		zapsentry.WithBreadcrumbs(zapcore.InfoLevel),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := zapsentryhttp.New(zap.New(core), zapsentryhttp.Options{}).
+		HandleFunc(func(w http.ResponseWriter, r *http.Request) {
+			zapsentry.LoggerFromContext(r.Context()).Info("handling request")
+			panic("boom")
+		})
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "http://example.com/users", nil))
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("expected status 500, got %d", rec.Code)
+	}
+	if len(events) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(events))
+	}
+	event := events[0]
+	if event.Level != sentry.LevelFatal {
+		t.Errorf("expected a fatal event, got %q", event.Level)
+	}
+	if event.Request == nil || event.Request.URL != "http://example.com/users" {
+		t.Errorf("expected the request on the event, got %+v", event.Request)
+	}
+	if len(event.Breadcrumbs) == 0 || event.Breadcrumbs[0].Message != "handling request" {
+		t.Errorf("expected the request breadcrumbs on the event, got %v", event.Breadcrumbs)
+	}
+}
+
+func TestHandlerScrubsRequest(t *testing.T) {
+	var events []*sentry.Event
+	client, err := sentry.NewClient(sentry.ClientOptions{
+		Transport: &transport{MockSendEvent: func(event *sentry.Event) {
+			events = append(events, event)
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	core, err := zapsentry.NewCore(
+		zapsentry.NewSentryClientFromClient(client),
+		zapsentry.WithScrubbing(zapsentry.ScrubConfig{}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := zapsentryhttp.New(zap.New(core), zapsentryhttp.Options{}).
+		HandleFunc(func(w http.ResponseWriter, r *http.Request) {
+			zapsentry.LoggerFromContext(r.Context()).Error("request failed")
+		})
+	r := httptest.NewRequest(http.MethodGet, "http://example.com/users?access_token=abc", nil)
+	r.Header.Set("Authorization", "Bearer supersecret")
+	handler(httptest.NewRecorder(), r)
+
+	if len(events) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(events))
+	}
+	request := events[0].Request
+	if request == nil || request.Headers["Authorization"] != "[Filtered]" ||
+		request.QueryString != "access_token=%5BFiltered%5D" {
+		t.Errorf("expected the request to be scrubbed, got %+v", request)
+	}
+}
+
+func panickingHandler(http.ResponseWriter, *http.Request) {
+	panic("boom")
+}
+
+func TestHandlerPanicEntry(t *testing.T) {
+	var events []*sentry.Event
+	client, err := sentry.NewClient(sentry.ClientOptions{
+		Transport: &transport{MockSendEvent: func(event *sentry.Event) {
+			events = append(events, event)
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	core, err := zapsentry.NewCore(
+		zapsentry.NewSentryClientFromClient(client),
+		zapsentry.WithFingerprinter(&zapsentry.ErrorTypeFingerprinter{}),
+		zapsentry.WithIgnoreRules(zapsentry.IgnoreRule{Loggers: []string{"ignored"}}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"api", "ignored"} {
+		handler := zapsentryhttp.New(zap.New(core).Named(name), zapsentryhttp.Options{}).
+			HandleFunc(panickingHandler)
+		handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://example.com", nil))
+	}
+
+	if len(events) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(events))
+	}
+	const caller = "github.com/l2cup/zapsentry/http_test.panickingHandler"
+	if fingerprint := events[0].Fingerprint; len(fingerprint) != 2 || fingerprint[1] != caller {
+		t.Errorf("expected the panic site as the caller, got fingerprint %v", fingerprint)
+	}
+	exceptions := events[0].Exception
+	if len(exceptions) == 0 || exceptions[len(exceptions)-1].Stacktrace == nil {
+		t.Fatalf("expected an exception with a stack trace, got %+v", exceptions)
+	}
+	frames := exceptions[len(exceptions)-1].Stacktrace.Frames
+	if len(frames) == 0 || frames[len(frames)-1].Function != "panickingHandler" {
+		t.Errorf("expected the stack trace to start at the panic site, got %+v", frames)
+	}
+}
+
+type transport struct {
+	MockSendEvent func(event *sentry.Event)
+}
+
+func (f *transport) Flush(_ time.Duration) bool { return true }
+
+func (f *transport) Configure(_ sentry.ClientOptions) {}
+
+func (f *transport) SendEvent(event *sentry.Event) { f.MockSendEvent(event) }
@@ -0,0 +1,187 @@
+package zapsentry
+
+import (
+	"errors"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// IgnoreRule declares entries which are known noise and shouldn't become events.
+// An entry matches a rule if it matches all of the rule's non-empty conditions, and it's
+// ignored if it matches any rule.
+type IgnoreRule struct {
+	// Errors match entries with an error field wrapping one of them, using errors.Is,
+	// e.g. context.Canceled or io.EOF.
+	Errors []error
+	// ErrorTypes match entries with an error field wrapping an error of one of the types,
+	// using errors.As. Like errors.As targets, they are pointers to the types, e.g.
+	// new(*net.OpError).
+	ErrorTypes []interface{}
+	// Messages match entries which message matches one of the patterns.
+	Messages []*regexp.Regexp
+	// Loggers match entries logged by one of the named loggers.
+	Loggers []string
+	// CallerPackages match entries logged from one of the packages, or their subpackages.
+	// The entry caller is used if the logger adds it, see zap.AddCaller. Otherwise the
+	// caller is looked up by walking the stack, which is slower.
+	CallerPackages []string
+
+	// KeepBreadcrumb keeps ignored entries as breadcrumbs, if breadcrumbs are enabled for
+	// their level. Otherwise they are dropped from sentry entirely.
+	KeepBreadcrumb bool
+}
+
+// validate returns an error if the rule has no conditions or invalid error types.
+func (r IgnoreRule) validate() error {
+	if len(r.Errors) == 0 && len(r.ErrorTypes) == 0 && len(r.Messages) == 0 &&
+		len(r.Loggers) == 0 && len(r.CallerPackages) == 0 {
+		return errors.New("ignore rule must have at least one condition")
+	}
+	for _, target := range r.ErrorTypes {
+		t := reflect.TypeOf(target)
+		if t == nil || t.Kind() != reflect.Ptr ||
+			(t.Elem().Kind() != reflect.Interface && !t.Elem().Implements(errorReflectType)) {
+			return errors.New("ignore rule error types must be pointers to error types")
+		}
+	}
+	for _, m := range r.Messages {
+		if m == nil {
+			return errors.New("ignore rule message patterns can't be nil")
+		}
+	}
+	return nil
+}
+
+// match returns true if the entry matches all the rule's conditions.
+func (r IgnoreRule) match(ent zapcore.Entry, errs []error) bool {
+	if len(r.Errors) > 0 && !anyError(errs, func(err error) bool { return r.matchError(err) }) {
+		return false
+	}
+	if len(r.ErrorTypes) > 0 && !anyError(errs, func(err error) bool { return r.matchErrorType(err) }) {
+		return false
+	}
+	if len(r.Messages) > 0 && !r.matchMessage(ent.Message) {
+		return false
+	}
+	if len(r.Loggers) > 0 && !containsString(r.Loggers, ent.LoggerName) {
+		return false
+	}
+	if len(r.CallerPackages) > 0 && !r.matchCallerPackage(ent.Caller) {
+		return false
+	}
+	return true
+}
+
+func (r IgnoreRule) matchError(err error) bool {
+	for _, target := range r.Errors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r IgnoreRule) matchErrorType(err error) bool {
+	for _, target := range r.ErrorTypes {
+		// errors.As sets the target, so every check gets a new one.
+		if errors.As(err, reflect.New(reflect.TypeOf(target).Elem()).Interface()) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r IgnoreRule) matchMessage(msg string) bool {
+	for _, m := range r.Messages {
+		if m.MatchString(msg) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r IgnoreRule) matchCallerPackage(caller zapcore.EntryCaller) bool {
+	pkg := callerPackage(callerFunction(caller))
+	if pkg == "" {
+		return false
+	}
+	for _, p := range r.CallerPackages {
+		if pkg == p || strings.HasPrefix(pkg, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// ignored returns the first rule the entry matches.
+// It returns false if the entry doesn't match any rule.
+func ignored(rules []IgnoreRule, ent zapcore.Entry, fs []zapcore.Field) (IgnoreRule, bool) {
+	if len(rules) == 0 {
+		return IgnoreRule{}, false
+	}
+	errs := errorsFromFields(fs)
+	for _, r := range rules {
+		if r.match(ent, errs) {
+			return r, true
+		}
+	}
+	return IgnoreRule{}, false
+}
+
+// packagePath is the import path of this package.
+var packagePath = reflect.TypeOf(IgnoreRule{}).PkgPath()
+
+// callerFunction returns the function of the entry caller. If the logger doesn't add the
+// caller, it's the first function on the stack outside of zap and this package.
+// It returns an empty string if the function isn't known.
+func callerFunction(caller zapcore.EntryCaller) string {
+	if caller.Function != "" {
+		return caller.Function
+	}
+
+	pcs := make([]uintptr, 64)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+	for {
+		frame, more := frames.Next()
+		pkg := callerPackage(frame.Function)
+		if pkg != packagePath && pkg != "go.uber.org/zap" && !strings.HasPrefix(pkg, "go.uber.org/zap/") {
+			return frame.Function
+		}
+		if !more {
+			return ""
+		}
+	}
+}
+
+// callerPackage returns the package path of a fully qualified function name, e.g.
+// github.com/l2cup/zapsentry for github.com/l2cup/zapsentry.(*core).Write.
+func callerPackage(function string) string {
+	slash := strings.LastIndexByte(function, '/')
+	dot := strings.IndexByte(function[slash+1:], '.')
+	if dot < 0 {
+		return ""
+	}
+	return function[:slash+1+dot]
+}
+
+func anyError(errs []error, match func(err error) bool) bool {
+	for _, err := range errs {
+		if match(err) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(ss []string, s string) bool {
+	for _, e := range ss {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -6,7 +6,7 @@ import (
 )
 
 type LevelEnabler struct {
-	level       zapcore.Level
+	level       zapcore.LevelEnabler
 	breadcrumbs *breadcrumbs
 }
 
@@ -14,6 +14,24 @@ func (l *LevelEnabler) Enabled(lvl zapcore.Level) bool {
 	return l.level.Enabled(lvl) || l.breadcrumbs.Enabled(lvl)
 }
 
+// levelOf returns the lowest level the LevelEnabler enables, or zapcore.FatalLevel+1 if it
+// doesn't enable any level.
+func levelOf(enab zapcore.LevelEnabler) zapcore.Level {
+	switch e := enab.(type) {
+	case zapcore.Level:
+		return e
+	case interface{ Level() zapcore.Level }:
+		// zap.AtomicLevel
+		return e.Level()
+	}
+	for lvl := zapcore.DebugLevel; lvl <= zapcore.FatalLevel; lvl++ {
+		if enab.Enabled(lvl) {
+			return lvl
+		}
+	}
+	return zapcore.FatalLevel + 1
+}
+
 // zapToSentryLevels maps all zap's debug levels to it's corresponding sentry level.
 var zapToSentryLevels = map[zapcore.Level]sentry.Level{
 	zapcore.DebugLevel:  sentry.LevelDebug,
@@ -1,12 +1,130 @@
 package zapsentry
 
 import (
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+
+	"github.com/getsentry/sentry-go"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
+// loggerKey is the context key of a scoped logger.
+type loggerKey struct{}
+
 func AttachCoreToLogger(sentryCore zapcore.Core, l *zap.Logger) *zap.Logger {
 	return l.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
 		return zapcore.NewTee(core, sentryCore)
 	}))
 }
+
+// NewScopedContext clones the sentry hub carried by the context, or the current hub if it
+// doesn't carry one, and returns a context carrying the clone and a logger bound to it.
+// The logger is stored in the returned context too, see LoggerFromContext.
+// It's used by middlewares to give every request it's own scope, which they set up through
+// the hub of the returned context, see sentry.GetHubFromContext.
+func NewScopedContext(ctx context.Context, logger *zap.Logger) (context.Context, *zap.Logger) {
+	hub := sentry.GetHubFromContext(ctx)
+	if hub == nil {
+		hub = sentry.CurrentHub()
+	}
+	hub = hub.Clone()
+	ctx = sentry.SetHubOnContext(ctx, hub)
+
+	logger = logger.With(Context(ctx))
+	return context.WithValue(ctx, loggerKey{}, logger), logger
+}
+
+// LoggerFromContext returns the scoped logger stored by NewScopedContext.
+// It returns a no-op logger if the context doesn't carry one.
+func LoggerFromContext(ctx context.Context) *zap.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*zap.Logger); ok {
+		return logger
+	}
+	return zap.NewNop()
+}
+
+// LogPanic logs the recovered value at fatal level, without exiting the program.
+// It should be called by the deferred function recovering the panic. The entry gets the
+// logger's name, and the panic site as it's caller and the start of it's stack traces.
+func LogPanic(logger *zap.Logger, recovered interface{}) {
+	err, ok := recovered.(error)
+	if !ok {
+		err = fmt.Errorf("%v", recovered)
+	}
+
+	// Checking a fatal entry with the logger fills in it's name, and it's stack trace if the
+	// logger adds one. It's never written, so the program doesn't exit.
+	ent := logger.Check(zapcore.FatalLevel, "panic: "+errorMessage(err)).Entry
+	fs := []zapcore.Field{zap.Error(err)}
+	if pcs := panicCallers(); len(pcs) > 0 {
+		frame, _ := runtime.CallersFrames(pcs).Next()
+		ent.Caller = zapcore.EntryCaller{
+			Defined:  true,
+			PC:       frame.PC,
+			File:     frame.File,
+			Line:     frame.Line,
+			Function: frame.Function,
+		}
+		if ent.Stack != "" {
+			ent.Stack = formatStack(pcs)
+		}
+		fs = append(fs, callersField(pcs))
+	}
+
+	// Checking the entry with the core bypasses the logger, which would exit the program.
+	if ce := logger.Core().Check(ent, nil); ce != nil {
+		ce.Write(fs...)
+	}
+}
+
+// panicCallers returns the program counters of the panicking goroutine, starting at the
+// panic site. It returns nil if the goroutine isn't panicking.
+func panicCallers() []uintptr {
+	pcs := make([]uintptr, 100)
+	pcs = pcs[:runtime.Callers(3, pcs)]
+	for i, pc := range pcs {
+		fn := runtime.FuncForPC(pc - 1)
+		if fn == nil || fn.Name() != "runtime.gopanic" {
+			continue
+		}
+		// Runtime panics, like nil dereferences, go through more runtime frames.
+		for i++; i < len(pcs); i++ {
+			if fn := runtime.FuncForPC(pcs[i] - 1); fn == nil || !strings.HasPrefix(fn.Name(), "runtime.") {
+				return pcs[i:]
+			}
+		}
+	}
+	return nil
+}
+
+// formatStack formats the program counters the way zap formats stack traces.
+// Like zap's, it leaves out Go runtime frames like runtime.goexit.
+func formatStack(pcs []uintptr) string {
+	var b strings.Builder
+	frames := runtime.CallersFrames(pcs)
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, "runtime.") {
+			if b.Len() > 0 {
+				b.WriteByte('\n')
+			}
+			fmt.Fprintf(&b, "%s\n\t%s:%d", frame.Function, frame.File, frame.Line)
+		}
+		if !more {
+			break
+		}
+	}
+	return b.String()
+}
+
+// callersField returns a field holding the program counters.
+// Exceptions get the stack trace of the first callers field.
+func callersField(pcs []uintptr) zapcore.Field {
+	f := zap.Skip()
+	f.Interface = pcs
+	f.Key = zapSentryCallersKey
+	return f
+}
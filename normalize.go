@@ -0,0 +1,77 @@
+package zapsentry
+
+import (
+	"regexp"
+)
+
+// originalMessageKey is the event extra key holding the message before normalization.
+const originalMessageKey = "original_message"
+
+// NormalizationRule replaces all the matches of it's pattern in a message with the
+// replacement. The replacement is expanded like in regexp.Regexp.ReplaceAllString.
+type NormalizationRule struct {
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+// DefaultNormalizationRules are the rules which are always applied by a MessageNormalizer.
+// They replace quoted strings, UUIDs, IP addresses, durations, numbers and hex IDs.
+// Order matters, as the more specific rules have to be applied first.
+var DefaultNormalizationRules = []NormalizationRule{
+	{
+		// Single quotes inside words, like in can't or user's, aren't quotes.
+		Pattern:     regexp.MustCompile(`"(?:[^"\\]|\\.)*"|\B'(?:[^'\\]|\\.)*'\B`),
+		Replacement: "<string>",
+	},
+	{
+		Pattern:     regexp.MustCompile(`\b[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}\b`),
+		Replacement: "<uuid>",
+	},
+	{
+		Pattern:     regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`),
+		Replacement: "<ip>",
+	},
+	{
+		Pattern:     regexp.MustCompile(`\b(?:[0-9a-fA-F]{1,4}:){7}[0-9a-fA-F]{1,4}\b|\b(?:[0-9a-fA-F]{1,4}:)+:(?:[0-9a-fA-F]{1,4}:)*[0-9a-fA-F]{1,4}\b`),
+		Replacement: "<ip>",
+	},
+	{
+		Pattern:     regexp.MustCompile(`\b(?:\d+(?:\.\d+)?(?:ns|us|µs|ms|s|m|h))+\b`),
+		Replacement: "<duration>",
+	},
+	{
+		Pattern:     regexp.MustCompile(`\b0[xX][0-9a-fA-F]+\b`),
+		Replacement: "<hex>",
+	},
+	{
+		Pattern:     regexp.MustCompile(`\b\d+(?:\.\d+)?\b`),
+		Replacement: "<num>",
+	},
+	{
+		Pattern:     regexp.MustCompile(`\b[0-9a-fA-F]*[0-9][0-9a-fA-F]*\b`),
+		Replacement: "<hex>",
+	},
+}
+
+// MessageNormalizer rewrites the variable parts of messages, so messages formatted with
+// fmt.Sprintf are grouped together by sentry.
+type MessageNormalizer struct {
+	rules []NormalizationRule
+}
+
+// NewMessageNormalizer returns a new MessageNormalizer.
+// The passed rules are applied before the DefaultNormalizationRules.
+func NewMessageNormalizer(rules ...NormalizationRule) *MessageNormalizer {
+	all := make([]NormalizationRule, 0, len(rules)+len(DefaultNormalizationRules))
+	all = append(all, rules...)
+	all = append(all, DefaultNormalizationRules...)
+	return &MessageNormalizer{rules: all}
+}
+
+// Normalize returns the message with all the rules applied.
+func (mn *MessageNormalizer) Normalize(msg string) string {
+	for _, r := range mn.rules {
+		msg = r.Pattern.ReplaceAllString(msg, r.Replacement)
+	}
+	return msg
+}
@@ -0,0 +1,270 @@
+package zapsentry
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"go.uber.org/zap/zapcore"
+)
+
+// defaultMaxRateLimitKeys is the default maximum number of per-key buckets.
+const defaultMaxRateLimitKeys = 1000
+
+// RateLimitKey defines what per-key rate limit buckets are keyed by.
+type RateLimitKey int
+
+const (
+	// RateLimitByNone disables per-key rate limiting.
+	RateLimitByNone RateLimitKey = iota
+	// RateLimitByLevel keys buckets by the entry level.
+	RateLimitByLevel
+	// RateLimitByLogger keys buckets by the logger name.
+	RateLimitByLogger
+	// RateLimitByMessage keys buckets by the normalized entry message.
+	RateLimitByMessage
+)
+
+// RateLimit configures token bucket rate limiting of events.
+// Rates are in events per second, a zero rate is unlimited.
+type RateLimit struct {
+	// Rate is the global rate, across all keys.
+	Rate float64
+	// Burst is the maximum number of events sent at once, above the global rate.
+	// Defaults to 1.
+	Burst int
+
+	// Key defines what per-key buckets are keyed by.
+	Key RateLimitKey
+	// KeyRate is the rate of every per-key bucket.
+	KeyRate float64
+	// KeyBurst is the maximum number of events sent at once, above the key rate.
+	// Defaults to 1.
+	KeyBurst int
+	// MaxKeys is the maximum number of per-key buckets kept. Defaults to 1000.
+	MaxKeys int
+
+	// SummaryInterval is how often a summary event reporting the number of dropped events
+	// is sent. Defaults to 1 minute.
+	SummaryInterval time.Duration
+}
+
+// tokenBucket is a token bucket, refilled at rate tokens per second up to burst tokens.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	if burst <= 0 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   now,
+	}
+}
+
+// allow takes a token and returns true if there is one.
+func (b *tokenBucket) allow(now time.Time) bool {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// rateLimiter limits the rate of events with a global bucket and per-key buckets, and
+// counts the dropped events per key.
+type rateLimiter struct {
+	cfg        RateLimit
+	normalizer *MessageNormalizer
+	clock      clock
+
+	mu      sync.Mutex
+	global  *tokenBucket
+	buckets map[string]*tokenBucket
+	dropped map[string]int
+
+	// stop stops the summary goroutine, which closes stopped once it returns.
+	stop     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+func newRateLimiter(cfg RateLimit, normalizer *MessageNormalizer, clock clock) (*rateLimiter, error) {
+	if cfg.Rate < 0 || cfg.KeyRate < 0 || cfg.SummaryInterval < 0 {
+		return nil, errors.New("rate limit rates and summary interval can't be negative")
+	}
+	if cfg.MaxKeys <= 0 {
+		cfg.MaxKeys = defaultMaxRateLimitKeys
+	}
+	if cfg.SummaryInterval == 0 {
+		cfg.SummaryInterval = time.Minute
+	}
+	if normalizer == nil {
+		normalizer = NewMessageNormalizer()
+	}
+
+	rl := &rateLimiter{
+		cfg:        cfg,
+		normalizer: normalizer,
+		clock:      clock,
+		buckets:    make(map[string]*tokenBucket),
+		dropped:    make(map[string]int),
+		stop:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+	if cfg.Rate > 0 {
+		rl.global = newTokenBucket(cfg.Rate, cfg.Burst, clock.Now())
+	}
+	return rl, nil
+}
+
+// allow returns true if an event for the entry can be sent.
+// The per-key bucket is checked first, so a hot key doesn't consume the global bucket.
+func (rl *rateLimiter) allow(ent zapcore.Entry) bool {
+	key := rl.key(ent)
+	keyed := rl.cfg.Key != RateLimitByNone
+	now := rl.clock.Now()
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	// Empty keys, like the root logger's name, are limited like any other key.
+	if keyed && rl.cfg.KeyRate > 0 && !rl.bucket(key, now).allow(now) {
+		rl.dropped[summaryKey(key, keyed)]++
+		return false
+	}
+	if rl.global != nil && !rl.global.allow(now) {
+		rl.dropped[summaryKey(key, keyed)]++
+		return false
+	}
+	return true
+}
+
+// summaryKey returns the key dropped events are counted by in the summary: global if events
+// aren't limited per key, or <empty> for the empty key.
+func summaryKey(key string, keyed bool) string {
+	switch {
+	case !keyed:
+		return "global"
+	case key == "":
+		return "<empty>"
+	default:
+		return key
+	}
+}
+
+// bucket returns the bucket of the key, creating it if needed.
+// If there are too many buckets an arbitrary one is evicted.
+func (rl *rateLimiter) bucket(key string, now time.Time) *tokenBucket {
+	if b, ok := rl.buckets[key]; ok {
+		return b
+	}
+	if len(rl.buckets) >= rl.cfg.MaxKeys {
+		for k := range rl.buckets {
+			delete(rl.buckets, k)
+			break
+		}
+	}
+	b := newTokenBucket(rl.cfg.KeyRate, rl.cfg.KeyBurst, now)
+	rl.buckets[key] = b
+	return b
+}
+
+// key returns the per-key bucket key of the entry.
+func (rl *rateLimiter) key(ent zapcore.Entry) string {
+	switch rl.cfg.Key {
+	case RateLimitByLevel:
+		return ent.Level.String()
+	case RateLimitByLogger:
+		return ent.LoggerName
+	case RateLimitByMessage:
+		return rl.normalizer.Normalize(ent.Message)
+	default:
+		return ""
+	}
+}
+
+// takeDropped returns the dropped counts per key and resets them.
+func (rl *rateLimiter) takeDropped() map[string]int {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	dropped := rl.dropped
+	rl.dropped = make(map[string]int)
+	return dropped
+}
+
+// run reports the dropped events every summary interval, until the rate limiter is closed.
+func (rl *rateLimiter) run(ev *events, hub func() *sentry.Hub) {
+	defer close(rl.stopped)
+	ticker := time.NewTicker(rl.cfg.SummaryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			rl.reportDropped(ev, hub)
+		case <-rl.stop:
+			return
+		}
+	}
+}
+
+// close stops the summary goroutine and waits for it to return.
+func (rl *rateLimiter) close() {
+	rl.stopOnce.Do(func() { close(rl.stop) })
+	<-rl.stopped
+}
+
+// reportDropped sends a summary event of the dropped events since the last summary, if
+// any events were dropped.
+func (rl *rateLimiter) reportDropped(ev *events, hub func() *sentry.Hub) {
+	if event := rl.summary(ev); event != nil {
+		_ = hub().CaptureEvent(event)
+	}
+}
+
+// summary returns an event reporting the dropped events since the last summary.
+// It returns nil if no events were dropped.
+// The keys can be messages, so sensitive values detected in them are scrubbed. Keys which
+// are equal once scrubbed share their count.
+func (rl *rateLimiter) summary(ev *events) *sentry.Event {
+	dropped := rl.takeDropped()
+	if len(dropped) == 0 {
+		return nil
+	}
+
+	total := 0
+	extra := make(map[string]interface{}, len(dropped))
+	for k, n := range dropped {
+		total += n
+		if ev.scrubber != nil {
+			k = ev.scrubber.scrubString(k)
+		}
+		count, _ := extra[k].(int)
+		extra[k] = count + n
+	}
+
+	event := sentry.NewEvent()
+	event.Message = fmt.Sprintf("zapsentry: dropped %d events due to rate limiting", total)
+	event.Level = sentry.LevelWarning
+	event.Timestamp = rl.clock.Now()
+	event.Platform = ev.platform
+	event.Extra = extra
+	if ev.environment != "" {
+		event.Environment = ev.environment
+	}
+	return event
+}
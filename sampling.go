@@ -0,0 +1,123 @@
+package zapsentry
+
+import (
+	"errors"
+	"hash/fnv"
+	"math"
+	"strings"
+
+	"github.com/getsentry/sentry-go"
+	"go.uber.org/zap/zapcore"
+)
+
+// SamplingRule sets the rate events of a level, and optionally a logger, are sent at.
+type SamplingRule struct {
+	// Level is the level of the entries the rule applies to.
+	Level zapcore.Level
+	// Logger is the name of the logger the rule applies to, empty applies to all loggers.
+	Logger string
+	// Rate is the fraction of events sent, from 0 to 1.
+	Rate float64
+}
+
+// sampler samples events by the sampling rules.
+// The decision is consistent for a fingerprint, so a sampled in issue is always sampled in.
+type sampler struct {
+	rules         []SamplingRule
+	normalizer    *MessageNormalizer
+	fingerprinter Fingerprinter
+}
+
+func newSampler(
+	rules []SamplingRule,
+	normalizer *MessageNormalizer,
+	fingerprinter Fingerprinter,
+) (*sampler, error) {
+	for _, r := range rules {
+		if r.Rate < 0 || r.Rate > 1 {
+			return nil, errors.New("sampling rate must be between 0 and 1")
+		}
+	}
+	return &sampler{rules: rules, normalizer: normalizer, fingerprinter: fingerprinter}, nil
+}
+
+// rate returns the sampling rate of the entry.
+// Rules for the entry's logger take precedence over the ones for all loggers.
+// Entries without a matching rule are always sent.
+func (s *sampler) rate(ent zapcore.Entry) float64 {
+	rate := 1.0
+	for _, r := range s.rules {
+		if r.Level != ent.Level {
+			continue
+		}
+		if r.Logger == ent.LoggerName {
+			return r.Rate
+		}
+		if r.Logger == "" {
+			rate = r.Rate
+		}
+	}
+	return rate
+}
+
+// sample returns true if the event for the entry should be sent.
+func (s *sampler) sample(ent zapcore.Entry, fs []zapcore.Field) bool {
+	rate := s.rate(ent)
+	if rate >= 1 {
+		return true
+	}
+	if rate <= 0 {
+		return false
+	}
+
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(s.fingerprint(ent, fs)))
+	return float64(mix(h.Sum64()))/math.MaxUint64 < rate
+}
+
+// mix is murmur3's 64-bit finalizer. FNV doesn't spread small differences in the input over
+// the high bits of the hash, which the sampling decision depends on.
+func mix(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// fingerprint returns the value sampling decisions are made on, the last Fingerprint field
+// if there is one, otherwise the fingerprint of the fingerprinter, or the logger name and the
+// message if there is no fingerprinter. The message is normalized if normalization is enabled.
+// Sampling happens before the event is built, so the fingerprinter gets the exceptions of the
+// error fields without stack traces, see fieldExceptions.
+func (s *sampler) fingerprint(ent zapcore.Entry, fs []zapcore.Field) string {
+	if fingerprint := findFingerprint(fs); fingerprint != nil {
+		return strings.Join(fingerprint, "\x00")
+	}
+	if s.normalizer != nil {
+		ent.Message = s.normalizer.Normalize(ent.Message)
+	}
+	if s.fingerprinter != nil {
+		return strings.Join(s.fingerprinter.Fingerprint(ent, fs, fieldExceptions(fs)), "\x00")
+	}
+	return ent.LoggerName + "\x00" + ent.Message
+}
+
+// fieldExceptions returns the exceptions of the error fields without stack traces, ordered
+// like the DefaultExceptionProvider orders them. It returns nil if there are no error fields.
+func fieldExceptions(fs []zapcore.Field) []sentry.Exception {
+	var exceptions []sentry.Exception
+	for _, err := range errorsFromFields(fs) {
+		start := len(exceptions)
+		for i := 0; i < maxErrorDepth && !isNilError(err); i++ {
+			exceptions = append(exceptions, sentry.Exception{Type: errorType(err), Value: errorMessage(err)})
+			err = unwrapError(err)
+		}
+		// Reverse the error's exceptions so the outermost error is the last one.
+		for i, j := start, len(exceptions)-1; i < j; i, j = i+1, j-1 {
+			exceptions[i], exceptions[j] = exceptions[j], exceptions[i]
+		}
+	}
+	return exceptions
+}
@@ -0,0 +1,437 @@
+package zapsentry
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/getsentry/sentry-go"
+)
+
+// scrubMask is the value masked data is replaced with.
+const scrubMask = "[Filtered]"
+
+// ScrubAction defines what happens to sensitive data.
+type ScrubAction int
+
+const (
+	// ScrubMask replaces sensitive data with [Filtered].
+	ScrubMask ScrubAction = iota
+	// ScrubHash replaces sensitive data with it's HMAC-SHA256, keyed with
+	// ScrubConfig.HashSecret, so equal values can still be correlated. The hash is keyed,
+	// since short values like card numbers can be brute forced from a plain hash.
+	ScrubHash
+	// ScrubDrop removes sensitive data. Keys are removed, detected values are removed from
+	// the strings containing them.
+	ScrubDrop
+)
+
+// ValueDetector detects sensitive values inside strings, regardless of their keys.
+type ValueDetector struct {
+	// Name describes the detected values.
+	Name string
+	// Pattern matches the sensitive values.
+	Pattern *regexp.Regexp
+	// Validate optionally confirms a match, e.g. with a checksum.
+	Validate func(match string) bool
+}
+
+// These are the built-in ValueDetectors.
+var (
+	// EmailDetector detects email addresses.
+	EmailDetector = ValueDetector{
+		Name:    "email",
+		Pattern: regexp.MustCompile(`[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}`),
+	}
+	// CardNumberDetector detects payment card numbers, validated with the Luhn checksum.
+	CardNumberDetector = ValueDetector{
+		Name:     "card number",
+		Pattern:  regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`),
+		Validate: luhn,
+	}
+	// BearerTokenDetector detects bearer tokens, like in Authorization headers.
+	BearerTokenDetector = ValueDetector{
+		Name:    "bearer token",
+		Pattern: regexp.MustCompile(`(?i)\bbearer\s+[a-zA-Z0-9._~+/-]+=*`),
+	}
+	// JWTDetector detects JSON web tokens.
+	JWTDetector = ValueDetector{
+		Name:    "jwt",
+		Pattern: regexp.MustCompile(`\beyJ[a-zA-Z0-9_-]+\.eyJ[a-zA-Z0-9_-]+\.[a-zA-Z0-9_-]*`),
+	}
+)
+
+// DefaultScrubKeys are the key patterns used when no key patterns are configured.
+// They match keys containing the words, e.g. db_password, access_token or X-Api-Token.
+var DefaultScrubKeys = []string{
+	"*password*",
+	"*passwd*",
+	"*token*",
+	"*authorization*",
+	"*cookie*",
+	"*secret*",
+}
+
+// DefaultValueDetectors are the detectors used when no detectors are configured.
+var DefaultValueDetectors = []ValueDetector{
+	BearerTokenDetector,
+	JWTDetector,
+	EmailDetector,
+	CardNumberDetector,
+}
+
+// ScrubConfig configures scrubbing sensitive data from events and breadcrumbs.
+type ScrubConfig struct {
+	// Keys are case insensitive patterns of keys which values are sensitive, e.g. password
+	// or *secret*. Patterns use path.Match syntax, so they match whole keys, password
+	// doesn't match db_password. Defaults to DefaultScrubKeys.
+	Keys []string
+	// Detectors detect sensitive values. Defaults to DefaultValueDetectors.
+	Detectors []ValueDetector
+	// Action defines what happens to sensitive data. Defaults to ScrubMask.
+	Action ScrubAction
+
+	// HashKeys are case insensitive patterns of keys which values are identifiers, e.g.
+	// user_id or account_*. Their values are replaced with their HMAC-SHA256, keyed with
+	// HashSecret, so events can be correlated without the raw identifiers being sent.
+	// They are matched against extra, tag and breadcrumb data keys. The sentry.User fields
+	// are matched by their names, with and without a user_ prefix, e.g. ip_address or
+	// user_id, and by the keys they are mapped from, see ConvertFieldsToUser.
+	HashKeys []string
+	// HashSecret is the HMAC key. It's required if there are HashKeys or the action is
+	// ScrubHash.
+	HashSecret []byte
+}
+
+// scrubber scrubs sensitive data from events and breadcrumbs.
+type scrubber struct {
+	keys      []string
+	detectors []ValueDetector
+	action    ScrubAction
+
+	hashKeys   []string
+	hashSecret []byte
+}
+
+func newScrubber(cfg ScrubConfig) (*scrubber, error) {
+	keys := cfg.Keys
+	if keys == nil {
+		keys = DefaultScrubKeys
+	}
+	detectors := cfg.Detectors
+	if detectors == nil {
+		detectors = DefaultValueDetectors
+	}
+
+	if (len(cfg.HashKeys) > 0 || cfg.Action == ScrubHash) && len(cfg.HashSecret) == 0 {
+		return nil, errors.New("hash secret is required to hash keys and values")
+	}
+
+	s := &scrubber{
+		detectors:  detectors,
+		action:     cfg.Action,
+		hashSecret: cfg.HashSecret,
+	}
+	var err error
+	if s.keys, err = keyPatterns(keys); err != nil {
+		return nil, err
+	}
+	if s.hashKeys, err = keyPatterns(cfg.HashKeys); err != nil {
+		return nil, err
+	}
+	for _, d := range detectors {
+		if d.Pattern == nil {
+			return nil, fmt.Errorf("value detector %q pattern can't be nil", d.Name)
+		}
+	}
+	return s, nil
+}
+
+// scrubEvent scrubs the event message, exceptions, fingerprint, tags, contexts, extra,
+// request and user. Exception types and fingerprints are scrubbed too, since they can be built from the
+// message. The user keys are the keys the user fields were mapped from.
+func (s *scrubber) scrubEvent(event *sentry.Event, userKeys UserKeys) {
+	event.Message = s.scrubString(event.Message)
+	for i := range event.Exception {
+		event.Exception[i].Type = s.scrubString(event.Exception[i].Type)
+		event.Exception[i].Value = s.scrubString(event.Exception[i].Value)
+	}
+	for i := range event.Fingerprint {
+		event.Fingerprint[i] = s.scrubString(event.Fingerprint[i])
+	}
+	event.Tags = s.scrubTags(event.Tags)
+	event.Contexts = s.scrubMap(event.Contexts)
+	event.Extra = s.scrubMap(event.Extra)
+	event.Request = s.scrubRequest(event.Request)
+	s.scrubUser(&event.User, userKeys)
+}
+
+// scrubUser hashes the user fields which names, or keys they are mapped from, match the hash
+// keys.
+func (s *scrubber) scrubUser(user *sentry.User, keys UserKeys) {
+	for _, field := range []struct {
+		name  string
+		key   string
+		value *string
+	}{
+		{"id", keys.ID, &user.ID},
+		{"email", keys.Email, &user.Email},
+		{"username", keys.Username, &user.Username},
+		{"ip_address", keys.IPAddress, &user.IPAddress},
+	} {
+		if *field.value == "" {
+			continue
+		}
+		if matchKey(s.hashKeys, field.name) || matchKey(s.hashKeys, "user_"+field.name) ||
+			(field.key != "" && matchKey(s.hashKeys, field.key)) {
+			*field.value = s.hmac(*field.value)
+		}
+	}
+}
+
+// scrubRequest returns a scrubbed copy of the request, which is shared by the events logged
+// with the same Request field. Headers, environment variables, query parameters, cookies
+// and form or JSON object bodies are scrubbed by their keys.
+func (s *scrubber) scrubRequest(r *sentry.Request) *sentry.Request {
+	if r == nil {
+		return nil
+	}
+	scrubbed := *r
+	scrubbed.URL = s.scrubString(r.URL)
+	scrubbed.Headers = s.scrubTags(r.Headers)
+	scrubbed.Env = s.scrubTags(r.Env)
+	scrubbed.QueryString = s.scrubPairs(r.QueryString, "&", url.QueryUnescape, url.QueryEscape)
+	scrubbed.Cookies = s.scrubCookies(r.Cookies)
+	scrubbed.Data = s.scrubData(r.Data, r.Headers["Content-Type"])
+	return &scrubbed
+}
+
+// scrubCookies scrubs the cookies as a whole if the Cookie header is sensitive, otherwise
+// every cookie is scrubbed by it's name.
+func (s *scrubber) scrubCookies(cookies string) string {
+	if matchKey(s.hashKeys, "cookie") || matchKey(s.keys, "cookie") {
+		scrubbed, _ := s.scrubKeyValue("cookie", cookies)
+		return scrubbed
+	}
+	unescape := func(v string) (string, error) { return v, nil }
+	escape := func(v string) string { return v }
+	return s.scrubPairs(cookies, ";", unescape, escape)
+}
+
+// scrubData scrubs the request body. Form and JSON object bodies are scrubbed by their
+// keys, other bodies only by the detected values.
+func (s *scrubber) scrubData(data, contentType string) string {
+	if data == "" {
+		return data
+	}
+	if strings.HasPrefix(contentType, "application/x-www-form-urlencoded") {
+		return s.scrubPairs(data, "&", url.QueryUnescape, url.QueryEscape)
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(data))
+	decoder.UseNumber()
+	var object map[string]interface{}
+	if err := decoder.Decode(&object); err == nil && !decoder.More() {
+		if scrubbed, err := json.Marshal(s.scrubMap(object)); err == nil {
+			return string(scrubbed)
+		}
+	}
+	return s.scrubString(data)
+}
+
+// scrubPairs scrubs the values of the key=value pairs separated by sep, like query strings.
+// Keys and values are matched and scrubbed unescaped, and scrubbed values are escaped.
+func (s *scrubber) scrubPairs(
+	pairs, sep string,
+	unescape func(string) (string, error),
+	escape func(string) string,
+) string {
+	if pairs == "" {
+		return pairs
+	}
+	split := strings.Split(pairs, sep)
+	scrubbed := make([]string, 0, len(split))
+	for _, pair := range split {
+		rawKey, rawValue := pair, ""
+		if i := strings.IndexByte(pair, '='); i >= 0 {
+			rawKey, rawValue = pair[:i], pair[i+1:]
+		}
+		key, err := unescape(strings.TrimSpace(rawKey))
+		if err != nil {
+			key = rawKey
+		}
+		value, err := unescape(rawValue)
+		if err != nil {
+			value = rawValue
+		}
+
+		v, ok := s.scrubKeyValue(key, value)
+		switch {
+		case !ok:
+		case v == value:
+			scrubbed = append(scrubbed, pair)
+		default:
+			scrubbed = append(scrubbed, rawKey+"="+escape(v))
+		}
+	}
+	return strings.Join(scrubbed, sep)
+}
+
+// scrubBreadcrumb scrubs the breadcrumb message and data.
+func (s *scrubber) scrubBreadcrumb(breadcrumb *sentry.Breadcrumb) {
+	breadcrumb.Message = s.scrubString(breadcrumb.Message)
+	breadcrumb.Data = s.scrubMap(breadcrumb.Data)
+}
+
+// scrubTags returns the scrubbed tags.
+// It's used for the other maps of strings too, like request headers.
+func (s *scrubber) scrubTags(tags map[string]string) map[string]string {
+	if tags == nil {
+		return nil
+	}
+	scrubbed := make(map[string]string, len(tags))
+	for k, v := range tags {
+		if v, ok := s.scrubKeyValue(k, v); ok {
+			scrubbed[k] = v
+		}
+	}
+	return scrubbed
+}
+
+// scrubKeyValue returns the scrubbed value of the key.
+// It returns false if the value is dropped.
+func (s *scrubber) scrubKeyValue(key, value string) (string, bool) {
+	if matchKey(s.hashKeys, key) {
+		return s.hmac(value), true
+	}
+	if !matchKey(s.keys, key) {
+		return s.scrubString(value), true
+	}
+	if s.action == ScrubDrop {
+		return "", false
+	}
+	return s.replace(value), true
+}
+
+// scrubMap returns a scrubbed copy of the map. The map is always copied, since event extra
+// and breadcrumb data are shared.
+func (s *scrubber) scrubMap(m map[string]interface{}) map[string]interface{} {
+	if m == nil {
+		return nil
+	}
+	scrubbed := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		if matchKey(s.hashKeys, k) {
+			scrubbed[k] = s.hmac(fmt.Sprint(v))
+			continue
+		}
+		if !matchKey(s.keys, k) {
+			scrubbed[k] = s.scrubValue(v)
+			continue
+		}
+		if s.action != ScrubDrop {
+			scrubbed[k] = s.replace(fmt.Sprint(v))
+		}
+	}
+	return scrubbed
+}
+
+// scrubValue scrubs strings and walks the objects and arrays encoded by zap.
+func (s *scrubber) scrubValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case string:
+		return s.scrubString(t)
+	case map[string]interface{}:
+		return s.scrubMap(t)
+	case []interface{}:
+		scrubbed := make([]interface{}, len(t))
+		for i, e := range t {
+			scrubbed[i] = s.scrubValue(e)
+		}
+		return scrubbed
+	}
+	return v
+}
+
+// scrubString replaces the sensitive values detected in the string.
+func (s *scrubber) scrubString(str string) string {
+	for _, d := range s.detectors {
+		str = d.Pattern.ReplaceAllStringFunc(str, func(match string) string {
+			if d.Validate != nil && !d.Validate(match) {
+				return match
+			}
+			if s.action == ScrubDrop {
+				return ""
+			}
+			return s.replace(match)
+		})
+	}
+	return str
+}
+
+// replace returns the replacement of a sensitive value.
+func (s *scrubber) replace(v string) string {
+	if s.action != ScrubHash {
+		return scrubMask
+	}
+	return s.hmac(v)
+}
+
+// hmac returns the hex encoded HMAC-SHA256 of the value, keyed with the hash secret.
+func (s *scrubber) hmac(v string) string {
+	mac := hmac.New(sha256.New, s.hashSecret)
+	_, _ = mac.Write([]byte(v))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// keyPatterns returns the lower cased key patterns, checking they are valid.
+func keyPatterns(patterns []string) ([]string, error) {
+	lower := make([]string, 0, len(patterns))
+	for _, p := range patterns {
+		p = strings.ToLower(p)
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid scrub key pattern %q: %w", p, err)
+		}
+		lower = append(lower, p)
+	}
+	return lower, nil
+}
+
+// matchKey returns true if the key matches one of the lower cased key patterns.
+func matchKey(patterns []string, key string) bool {
+	key = strings.ToLower(key)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// luhn returns true if the digits in the string pass the Luhn checksum.
+func luhn(s string) bool {
+	sum, n := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n >= 13 && sum%10 == 0
+}
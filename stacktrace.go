@@ -0,0 +1,158 @@
+package zapsentry
+
+import (
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/getsentry/sentry-go"
+)
+
+// StacktraceExtractor extracts the stack trace carried by an error.
+// Error libraries like github.com/pkg/errors record the stack trace of where the error was
+// created, which is more useful than the stack trace of where the error was logged.
+type StacktraceExtractor interface {
+	// Extract returns the program counters of the stack trace carried by err.
+	// It returns nil if err doesn't carry a stack trace known to the extractor.
+	Extract(err error) []uintptr
+}
+
+// StacktraceExtractorFunc is an adapter to allow the use of ordinary functions as
+// StacktraceExtractors.
+type StacktraceExtractorFunc func(err error) []uintptr
+
+// Extract calls f(err).
+func (f StacktraceExtractorFunc) Extract(err error) []uintptr { return f(err) }
+
+// StacktraceExtractors is a registry of StacktraceExtractors.
+// Extractors are tried in order and the first one which returns a stack trace wins.
+type StacktraceExtractors []StacktraceExtractor
+
+var _ StacktraceExtractor = (StacktraceExtractors)(nil)
+
+// DefaultStacktraceExtractors are the StacktraceExtractors which are always registered.
+// They recognise errors from github.com/pkg/errors, github.com/cockroachdb/errors,
+// github.com/pingcap/errors and github.com/go-errors/errors.
+// Reflection is used so we don't have a hard dependency on any of these packages.
+var DefaultStacktraceExtractors = StacktraceExtractors{
+	StacktraceExtractorFunc(extractPkgErrorsStacktrace),
+	StacktraceExtractorFunc(extractGoErrorsStacktrace),
+}
+
+// Extract returns the program counters returned by the first extractor which recognises
+// err. It returns nil if none of the extractors recognise it.
+func (se StacktraceExtractors) Extract(err error) []uintptr {
+	for _, e := range se {
+		if pcs := e.Extract(err); len(pcs) > 0 {
+			return pcs
+		}
+	}
+	return nil
+}
+
+// Stacktrace returns the stack trace carried by err converted to sentry frames.
+// It returns nil if none of the extractors recognise err.
+func (se StacktraceExtractors) Stacktrace(err error) *sentry.Stacktrace {
+	pcs := se.Extract(err)
+	if len(pcs) == 0 {
+		return nil
+	}
+	return &sentry.Stacktrace{Frames: framesFromPCs(pcs)}
+}
+
+// extractPkgErrorsStacktrace extracts the stack trace of errors which implement
+// StackTrace() with a result of []uintptr kind, like github.com/pkg/errors StackTrace.
+// github.com/cockroachdb/errors uses the same StackTrace type, and github.com/pingcap/errors
+// exposes it through GetStackTracer().
+func extractPkgErrorsStacktrace(err error) []uintptr {
+	v := reflect.ValueOf(err)
+	if m := v.MethodByName("GetStackTracer"); m.IsValid() && m.Type().NumIn() == 0 && m.Type().NumOut() == 1 {
+		v = m.Call(nil)[0]
+	}
+	return pcsFromMethod(v.MethodByName("StackTrace"))
+}
+
+// extractGoErrorsStacktrace extracts the stack trace of github.com/go-errors/errors errors.
+func extractGoErrorsStacktrace(err error) []uintptr {
+	return pcsFromMethod(reflect.ValueOf(err).MethodByName("StackFrames"))
+}
+
+// pcsFromMethod calls the passed method and converts it's result to program counters.
+// The result has to be a slice of uintptr kind, or a slice of structs with a ProgramCounter
+// field of uintptr kind.
+func pcsFromMethod(method reflect.Value) []uintptr {
+	if !method.IsValid() || method.Type().NumIn() != 0 || method.Type().NumOut() != 1 {
+		return nil
+	}
+	trace := method.Call(nil)[0]
+	if trace.Kind() != reflect.Slice {
+		return nil
+	}
+
+	pcs := make([]uintptr, 0, trace.Len())
+	for i := 0; i < trace.Len(); i++ {
+		pc := trace.Index(i)
+		if pc.Kind() == reflect.Struct {
+			pc = pc.FieldByName("ProgramCounter")
+		}
+		if pc.IsValid() && pc.Kind() == reflect.Uintptr {
+			pcs = append(pcs, uintptr(pc.Uint()))
+		}
+	}
+	return pcs
+}
+
+// framesFromPCs converts program counters to sentry frames.
+// Sentry expects frames ordered from the oldest to the newest call, the opposite of
+// runtime.Callers order.
+func framesFromPCs(pcs []uintptr) []sentry.Frame {
+	frames := make([]sentry.Frame, 0, len(pcs))
+	callersFrames := runtime.CallersFrames(pcs)
+	for {
+		callerFrame, more := callersFrames.Next()
+		frame := sentry.NewFrame(callerFrame)
+		// Skip Go runtime frames like runtime.goexit.
+		if frame.Module != "runtime" {
+			frames = append(frames, frame)
+		}
+		if !more {
+			break
+		}
+	}
+
+	for i, j := 0, len(frames)-1; i < j; i, j = i+1, j-1 {
+		frames[i], frames[j] = frames[j], frames[i]
+	}
+	return frames
+}
+
+// parseEntryStack parses the stack trace zap formats into zapcore.Entry.Stack when a logger
+// is built with zap.AddStacktrace. Every frame is formatted as the function name followed by
+// a tab indented file:line line, newest call first.
+// It returns nil if the stack can't be parsed.
+func parseEntryStack(stack string) []sentry.Frame {
+	lines := strings.Split(strings.TrimSpace(stack), "\n")
+	if len(lines)%2 != 0 {
+		return nil
+	}
+
+	frames := make([]sentry.Frame, 0, len(lines)/2)
+	for i := len(lines) - 2; i >= 0; i -= 2 {
+		location := strings.TrimPrefix(lines[i+1], "\t")
+		sep := strings.LastIndexByte(location, ':')
+		if sep < 0 {
+			return nil
+		}
+		line, err := strconv.Atoi(location[sep+1:])
+		if err != nil {
+			return nil
+		}
+		frames = append(frames, sentry.NewFrame(runtime.Frame{
+			Function: lines[i],
+			File:     location[:sep],
+			Line:     line,
+		}))
+	}
+	return frames
+}
@@ -0,0 +1,191 @@
+package zapsentry
+
+import (
+	"encoding"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// tagErrorsKey is the event extra key holding the reasons tags were dropped.
+const tagErrorsKey = "tag_errors"
+
+// These constants define sentry's tag limits.
+//
+// https://docs.sentry.io/platforms/go/enriching-events/tags/
+const (
+	// maxTagKeyLength is the maximum length of a tag key.
+	maxTagKeyLength = 32
+	// maxTagValueLength is the maximum length of a tag value.
+	maxTagValueLength = 200
+)
+
+// tagKeyPattern matches the characters allowed in tag keys.
+var tagKeyPattern = regexp.MustCompile(`^[a-zA-Z0-9_.:-]+$`)
+
+// tagValue returns the canonical string form of the field's value.
+// It returns false if the field's type can't be converted to a tag value.
+func tagValue(f zapcore.Field) (string, bool) {
+	switch f.Type {
+	case zapcore.StringType:
+		return f.String, true
+	case zapcore.ByteStringType:
+		b, ok := f.Interface.([]byte)
+		return string(b), ok
+	case zapcore.BoolType:
+		return strconv.FormatBool(f.Integer == 1), true
+	case zapcore.Int64Type, zapcore.Int32Type, zapcore.Int16Type, zapcore.Int8Type:
+		return strconv.FormatInt(f.Integer, 10), true
+	case zapcore.Uint64Type, zapcore.Uint32Type, zapcore.Uint16Type, zapcore.Uint8Type,
+		zapcore.UintptrType:
+		return strconv.FormatUint(uint64(f.Integer), 10), true
+	case zapcore.Float64Type:
+		return strconv.FormatFloat(math.Float64frombits(uint64(f.Integer)), 'g', -1, 64), true
+	case zapcore.Float32Type:
+		return strconv.FormatFloat(float64(math.Float32frombits(uint32(f.Integer))), 'g', -1, 32), true
+	case zapcore.DurationType:
+		return time.Duration(f.Integer).String(), true
+	case zapcore.TimeType:
+		t := time.Unix(0, f.Integer)
+		if loc, ok := f.Interface.(*time.Location); ok {
+			t = t.In(loc)
+		}
+		return t.Format(time.RFC3339Nano), true
+	case zapcore.TimeFullType:
+		t, ok := f.Interface.(time.Time)
+		return t.Format(time.RFC3339Nano), ok
+	case zapcore.StringerType, zapcore.ObjectMarshalerType, zapcore.ReflectType:
+		return textValue(f.Interface)
+	}
+	return "", false
+}
+
+// textValue returns the text of encoding.TextMarshalers and fmt.Stringers.
+// Panics are recovered like zap does, as nil pointers often panic.
+func textValue(v interface{}) (s string, ok bool) {
+	defer func() {
+		if recover() != nil {
+			s, ok = "", false
+		}
+	}()
+
+	switch t := v.(type) {
+	case encoding.TextMarshaler:
+		b, err := t.MarshalText()
+		return string(b), err == nil
+	case fmt.Stringer:
+		return t.String(), true
+	}
+	return "", false
+}
+
+// validateTags applies sentry's tag limits. Values which are too long are truncated.
+// Tags with invalid keys or values are dropped and the reasons are returned.
+func validateTags(tags map[string]string) []string {
+	var violations []string
+	for k, v := range tags {
+		switch {
+		case len(k) > maxTagKeyLength:
+			violations = append(violations, fmt.Sprintf("tag key %q is longer than %d characters", k, maxTagKeyLength))
+		case !tagKeyPattern.MatchString(k):
+			violations = append(violations, fmt.Sprintf("tag key %q contains invalid characters", k))
+		case strings.ContainsRune(v, '\n'):
+			violations = append(violations, fmt.Sprintf("tag %q value contains a newline", k))
+		default:
+			if len(v) > maxTagValueLength {
+				tags[k] = truncate(v, maxTagValueLength)
+			}
+			continue
+		}
+		delete(tags, k)
+	}
+	sort.Strings(violations)
+	return violations
+}
+
+// truncate truncates s to at most n bytes, without splitting a multi-byte character.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !isRuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// isRuneStart returns true if the byte is the first byte of an UTF-8 encoded character.
+func isRuneStart(b byte) bool { return b&0xC0 != 0x80 }
+
+// tagsFromPaths returns the tags mapped from field keys or paths, see MapFieldsToTags.
+// Field keys are converted from the fields with their original types, paths are looked up
+// in the encoded fields.
+func tagsFromPaths(mapping map[string]string, fs []zapcore.Field, fields map[string]interface{}) map[string]string {
+	tags := make(map[string]string, len(mapping))
+	for i := len(fs) - 1; i >= 0; i-- {
+		tag, ok := mapping[fs[i].Key]
+		if _, set := tags[tag]; !ok || set {
+			continue
+		}
+		if v, ok := tagValue(fs[i]); ok {
+			tags[tag] = v
+		}
+	}
+
+	for path, tag := range mapping {
+		if _, set := tags[tag]; set {
+			continue
+		}
+		if v, ok := lookupPath(fields, path); ok {
+			if s, ok := encodedTagValue(v); ok {
+				tags[tag] = s
+			}
+		}
+	}
+	return tags
+}
+
+// lookupPath returns the value at the dot separated path in the encoded fields, walking
+// nested objects. Keys containing dots are supported, the whole remaining path is always
+// tried as a key first.
+func lookupPath(fields map[string]interface{}, path string) (interface{}, bool) {
+	if v, ok := fields[path]; ok {
+		return v, true
+	}
+	for i := 0; i < len(path); i++ {
+		if path[i] != '.' {
+			continue
+		}
+		object, ok := fields[path[:i]].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if v, ok := lookupPath(object, path[i+1:]); ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// encodedTagValue returns the string form of a scalar value encoded by a
+// zapcore.MapObjectEncoder. It returns false for objects, arrays and nil values.
+func encodedTagValue(v interface{}) (string, bool) {
+	switch t := v.(type) {
+	case nil, map[string]interface{}, []interface{}:
+		return "", false
+	case string:
+		return t, true
+	case time.Time:
+		return t.Format(time.RFC3339Nano), true
+	case fmt.Stringer:
+		return textValue(t)
+	default:
+		return fmt.Sprint(t), true
+	}
+}
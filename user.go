@@ -0,0 +1,61 @@
+package zapsentry
+
+import (
+	"fmt"
+
+	"github.com/getsentry/sentry-go"
+	"go.uber.org/zap/zapcore"
+)
+
+const zapSentryUserKey = "_zapsentry_user_"
+
+// UserKeys declares which field keys fill the sentry.User fields.
+// Empty keys aren't mapped.
+type UserKeys struct {
+	ID        string
+	Email     string
+	Username  string
+	IPAddress string
+}
+
+// User sets the user of the logged event.
+// It's fields take precedence over the ones mapped from fields with ConvertFieldsToUser.
+func User(user sentry.User) zapcore.Field {
+	return skipField(zapSentryUserKey, user)
+}
+
+// getUser returns the user of a User field.
+// It returns false if the field isn't a User field.
+func getUser(field zapcore.Field) (sentry.User, bool) {
+	if field.Type == zapcore.SkipType && field.Key == zapSentryUserKey {
+		user, ok := field.Interface.(sentry.User)
+		return user, ok
+	}
+	return sentry.User{}, false
+}
+
+// user returns the user of the last User field, with the missing sentry.User fields
+// filled from the fields mapped by the keys.
+func (k UserKeys) user(fs []zapcore.Field, fields map[string]interface{}) sentry.User {
+	var user sentry.User
+	for i := len(fs) - 1; i >= 0; i-- {
+		if u, ok := getUser(fs[i]); ok {
+			user = u
+			break
+		}
+	}
+
+	fill := func(dst *string, key string) {
+		if *dst != "" || key == "" {
+			return
+		}
+		if v, ok := fields[key]; ok && v != nil {
+			*dst = fmt.Sprint(v)
+		}
+	}
+	fill(&user.ID, k.ID)
+	fill(&user.Email, k.Email)
+	fill(&user.Username, k.Username)
+	fill(&user.IPAddress, k.IPAddress)
+	return user
+}